@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// BatchThreshold is the number of messages from which commands that generate
+// many messages at once, such as reword, send them through the Message
+// Batches API, which costs less but may take a while. A batch goes to the
+// first model only; what it fails is retried with the fallback models.
+var BatchThreshold = 20
+
+// batchPollInterval is how often the status of a batch is checked.
+var batchPollInterval = 30 * time.Second
+
+// messageBatch is the status of a Message Batches API batch.
+type messageBatch struct {
+	ID               string `json:"id"`
+	ProcessingStatus string `json:"processing_status"`
+	ResultsURL       string `json:"results_url"`
+	RequestCounts    struct {
+		Processing int `json:"processing"`
+		Succeeded  int `json:"succeeded"`
+		Errored    int `json:"errored"`
+		Canceled   int `json:"canceled"`
+		Expired    int `json:"expired"`
+	} `json:"request_counts"`
+}
+
+// batchResult is a line of the results of a batch.
+type batchResult struct {
+	CustomID string `json:"custom_id"`
+	Result   struct {
+		Type    string          `json:"type"`
+		Message messageResponse `json:"message"`
+		Error   struct {
+			Error struct {
+				Type    string `json:"type"`
+				Message string `json:"message"`
+			} `json:"error"`
+		} `json:"error"`
+	} `json:"result"`
+}
+
+// sendBatch sends each of prompts to model in one batch, and waits for the
+// results. The responses and errors are in the order of prompts; an error
+// for one prompt does not fail the others.
+func sendBatch(ctx context.Context, model modelSpec, prompts []string) ([]messageResponse, []error, error) {
+	apiKey, _, err := model.credentials().resolve(ctx)
+	if err != nil {
+		return nil, nil, &stageError{Stage: stageCredentials, Err: err}
+	}
+	thinking := ThinkingBudget > 0 && supportsThinking(model.Name)
+	type batchRequest struct {
+		CustomID string                 `json:"custom_id"`
+		Params   map[string]interface{} `json:"params"`
+	}
+	requests := make([]batchRequest, len(prompts))
+	for i, prompt := range prompts {
+		requests[i] = batchRequest{CustomID: fmt.Sprintf("request-%d", i), Params: messageRequest(model, prompt, thinking)}
+	}
+
+	url := strings.TrimSuffix(model.endpoint(), "/") + "/batches"
+	var batch messageBatch
+	if err := postAPI(ctx, model, apiKey, url, map[string]interface{}{"requests": requests}, &batch); err != nil {
+		return nil, nil, &stageError{Stage: stageAPI, Err: err}
+	}
+	fmt.Fprintf(os.Stderr, "commitgpt: sent %d requests in batch %s\n", len(prompts), batch.ID)
+	for batch.ProcessingStatus != "ended" {
+		select {
+		case <-ctx.Done():
+			cancelBatch(model, apiKey, url+"/"+batch.ID)
+			return nil, nil, ctx.Err()
+		case <-time.After(batchPollInterval):
+		}
+		if err := getAPI(ctx, model, apiKey, url+"/"+batch.ID, &batch); err != nil {
+			return nil, nil, &stageError{Stage: stageAPI, Err: err}
+		}
+		if Verbose {
+			fmt.Fprintf(os.Stderr, "commitgpt: batch %s: %d processing, %d succeeded, %d errored\n",
+				batch.ID, batch.RequestCounts.Processing, batch.RequestCounts.Succeeded, batch.RequestCounts.Errored)
+		}
+	}
+
+	responses := make([]messageResponse, len(prompts))
+	errs := make([]error, len(prompts))
+	for i := range errs {
+		errs[i] = errors.New("no result in the batch")
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", batch.ResultsURL, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	resp, err := doAPI(model, apiKey, req)
+	if err != nil {
+		return nil, nil, &stageError{Stage: stageAPI, Err: err}
+	}
+	defer resp.Body.Close()
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(nil, 16<<20)
+	for scanner.Scan() {
+		var result batchResult
+		if err := json.Unmarshal(scanner.Bytes(), &result); err != nil {
+			return nil, nil, &stageError{Stage: stageAPI, Err: fmt.Errorf("batch results: %w", err)}
+		}
+		var i int
+		if _, err := fmt.Sscanf(result.CustomID, "request-%d", &i); err != nil || i < 0 || i >= len(prompts) {
+			continue
+		}
+		switch result.Result.Type {
+		case "succeeded":
+			responses[i], errs[i] = result.Result.Message, checkResponse(result.Result.Message)
+		case "errored":
+			errs[i] = &apiError{Type: result.Result.Error.Error.Type, Message: result.Result.Error.Error.Message}
+		default:
+			errs[i] = fmt.Errorf("request %s", result.Result.Type)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, nil, &stageError{Stage: stageAPI, Err: err}
+	}
+	return responses, errs, nil
+}
+
+// getAPI gets url, authenticated for model, and decodes the response into v.
+func getAPI(ctx context.Context, model modelSpec, apiKey, url string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := doAPI(model, apiKey, req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// cancelBatch asks for the batch at url to be cancelled, after the command
+// was interrupted, so that it is not billed for.
+func cancelBatch(model modelSpec, apiKey, url string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	var batch messageBatch
+	if err := postAPI(ctx, model, apiKey, url+"/cancel", map[string]interface{}{}, &batch); err != nil {
+		fmt.Fprintf(os.Stderr, "commitgpt: cancelling the batch: %v\n", err)
+	}
+}
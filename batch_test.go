@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_sendBatch(t *testing.T) {
+	var polls int
+	var requests []struct {
+		CustomID string `json:"custom_id"`
+		Params   struct {
+			Model    string `json:"model"`
+			Messages []struct {
+				Content string `json:"content"`
+			} `json:"messages"`
+		} `json:"params"`
+	}
+	var ts *httptest.Server
+	ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("x-api-key") != "test-api-key" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch {
+		case r.Method == "POST" && r.URL.Path == "/v1/messages/batches":
+			var body struct {
+				Requests json.RawMessage `json:"requests"`
+			}
+			json.NewDecoder(r.Body).Decode(&body)
+			json.Unmarshal(body.Requests, &requests)
+			fmt.Fprint(w, `{"id": "msgbatch_1", "processing_status": "in_progress"}`)
+		case r.Method == "GET" && r.URL.Path == "/v1/messages/batches/msgbatch_1":
+			polls++
+			status := "in_progress"
+			if polls > 1 {
+				status = "ended"
+			}
+			fmt.Fprintf(w, `{"id": "msgbatch_1", "processing_status": %q, "results_url": %q}`, status, ts.URL+"/results")
+		case r.Method == "GET" && r.URL.Path == "/results":
+			// Results are not in the order of the requests.
+			fmt.Fprintln(w, `{"custom_id": "request-2", "result": {"type": "expired"}}`)
+			fmt.Fprintln(w, `{"custom_id": "request-0", "result": {"type": "succeeded", "message": {"stop_reason": "end_turn", "content": [{"type": "text", "text": "zero"}]}}}`)
+			fmt.Fprintln(w, `{"custom_id": "request-1", "result": {"type": "errored", "error": {"type": "error", "error": {"type": "invalid_request_error", "message": "too long"}}}}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer ts.Close()
+	defer func(interval time.Duration) { batchPollInterval = interval }(batchPollInterval)
+	batchPollInterval = time.Millisecond
+	t.Setenv("ANTHROPIC_API_KEY", "test-api-key")
+
+	model := modelSpec{Name: "claude-test"}
+	defer func(endpoint string) { Endpoint = endpoint }(Endpoint)
+	Endpoint = ts.URL + "/v1/messages"
+	responses, errs, err := sendBatch(context.Background(), model, []string{"a", "b", "c"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(requests) != 3 || requests[1].CustomID != "request-1" || requests[1].Params.Messages[0].Content != "b" ||
+		requests[1].Params.Model != "claude-test" {
+		t.Errorf("requests = %+v", requests)
+	}
+	if polls != 2 {
+		t.Errorf("polled %d times, want 2", polls)
+	}
+	if responses[0].Text() != "zero" || errs[0] != nil {
+		t.Errorf("result 0 = %q, %v", responses[0].Text(), errs[0])
+	}
+	if errs[1] == nil || !strings.Contains(errs[1].Error(), "too long") {
+		t.Errorf("result 1 err = %v, want too long", errs[1])
+	}
+	if errs[2] == nil || !strings.Contains(errs[2].Error(), "expired") {
+		t.Errorf("result 2 err = %v, want expired", errs[2])
+	}
+}
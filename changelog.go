@@ -0,0 +1,257 @@
+package main
+
+import (
+	"context"
+	_ "embed"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+//go:embed changelog-prompt.txt
+var changelogPromptData string
+
+// changelogSections are the sections of a release in Keep a Changelog, in
+// order.
+var changelogSections = []string{"Added", "Changed", "Deprecated", "Removed", "Fixed", "Security"}
+
+// changelogTypes are the sections of the conventional commit types that
+// users notice. Commits of other types, such as docs or test, are left out
+// unless they are breaking changes.
+var changelogTypes = map[string]string{
+	"feat":      "Added",
+	"fix":       "Fixed",
+	"perf":      "Changed",
+	"refactor":  "Changed",
+	"revert":    "Changed",
+	"build":     "Changed",
+	"deps":      "Changed",
+	"deprecate": "Deprecated",
+	"remove":    "Removed",
+	"security":  "Security",
+}
+
+// changelogHeader starts a new changelog file.
+const changelogHeader = `# Changelog
+
+All notable changes to this project will be documented in this file.
+
+The format is based on [Keep a Changelog](https://keepachangelog.com/en/1.1.0/),
+and this project adheres to [Semantic Versioning](https://semver.org/spec/v2.0.0.html).
+`
+
+// release is the changelog entry of a version: its entries by section.
+type release struct {
+	Version string
+	Date    string
+	Summary string
+	Entries map[string][]conventionalCommit
+}
+
+func changelogCommand(ctx context.Context, config gitConfig, args []string) int {
+	flags := flag.NewFlagSet("commitgpt changelog", flag.ContinueOnError)
+	version := flags.String("version", "", "the `name` of the release; by default the tag at the end of the range, or Unreleased")
+	summary := flags.Bool("summary", false, "ask the model for a summary of the release")
+	all := flags.Bool("all", false, "include every commit, not only those that users notice")
+	prepend := flags.String("prepend", "", "add the release to the top of `file`, such as CHANGELOG.md, instead of writing it to stdout")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() > 1 {
+		fmt.Fprintf(os.Stderr, "commitgpt changelog: unexpected argument %q\n", flags.Arg(1))
+		return 2
+	}
+
+	if err := runChangelog(ctx, flags.Arg(0), *version, *summary, *all, *prepend); err != nil {
+		fmt.Fprintln(os.Stderr, "commitgpt changelog:", err)
+		return 1
+	}
+	return 0
+}
+
+func runChangelog(ctx context.Context, rev, version string, summary, all bool, prepend string) error {
+	from, to, err := parseRevRange(ctx, rev)
+	if err != nil {
+		return err
+	}
+	commits, err := rangeCommits(ctx, revisionRange(from, to))
+	if err != nil {
+		return err
+	}
+	rel := release{Version: version, Entries: groupChangelog(commits, all)}
+	if rel.Version == "" {
+		if out, err := git(ctx, "describe", "--tags", "--exact-match", to); err == nil {
+			rel.Version = strings.TrimSpace(string(out))
+		} else {
+			rel.Version = "Unreleased"
+		}
+	}
+	if rel.Version != "Unreleased" {
+		out, err := git(ctx, "log", "-1", "--date=short", "--format=%cd", to)
+		if err != nil {
+			return err
+		}
+		rel.Date = strings.TrimSpace(string(out))
+	}
+	if summary && len(rel.Entries) > 0 {
+		if rel.Summary, err = summariseRelease(ctx, rel); err != nil {
+			return err
+		}
+	}
+
+	if prepend == "" {
+		_, err = fmt.Print(rel.String())
+		return err
+	}
+	existing, err := os.ReadFile(prepend)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return os.WriteFile(prepend, []byte(prependRelease(string(existing), rel.String())), 0644)
+}
+
+// parseRevRange splits rev, such as v1.0.0..v1.1.0, into its ends. A missing
+// end is HEAD, and with no rev at all the range starts at the last tag before
+// HEAD. from is empty when the range starts at the first commit.
+func parseRevRange(ctx context.Context, rev string) (from, to string, err error) {
+	if rev == "" {
+		return lastTag(ctx, "HEAD^"), "HEAD", nil
+	}
+	from, to, ok := strings.Cut(rev, "..")
+	if !ok || strings.HasPrefix(to, ".") {
+		return "", "", fmt.Errorf("%q is not a range like v1.0.0..HEAD", rev)
+	}
+	if to == "" {
+		to = "HEAD"
+	}
+	if from == "" {
+		from = "HEAD"
+	}
+	return from, to, nil
+}
+
+// revisionRange is the git revision range from..to, or all of to when from is
+// empty.
+func revisionRange(from, to string) string {
+	if from == "" {
+		return to
+	}
+	return from + ".." + to
+}
+
+// groupChangelog sorts commits into the sections of a changelog. Within a
+// section, commits are ordered by scope and then as given. Breaking changes
+// are always included, in Changed unless their type has a section.
+func groupChangelog(commits []conventionalCommit, all bool) map[string][]conventionalCommit {
+	entries := map[string][]conventionalCommit{}
+	for _, c := range commits {
+		section, ok := changelogTypes[c.Type]
+		if !ok {
+			if !all && !c.Breaking {
+				continue
+			}
+			section = "Changed"
+		}
+		entries[section] = append(entries[section], c)
+	}
+	for _, section := range entries {
+		sort.SliceStable(section, func(i, j int) bool { return section[i].Scope < section[j].Scope })
+	}
+	return entries
+}
+
+// String renders the release in Keep a Changelog Markdown.
+func (r release) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "## [%s]", r.Version)
+	if r.Date != "" {
+		fmt.Fprintf(&b, " - %s", r.Date)
+	}
+	b.WriteString("\n\n")
+	if r.Summary != "" {
+		b.WriteString(r.Summary + "\n\n")
+	}
+	for _, section := range changelogSections {
+		commits := r.Entries[section]
+		if len(commits) == 0 {
+			continue
+		}
+		fmt.Fprintf(&b, "### %s\n\n", section)
+		for _, c := range commits {
+			b.WriteString("- ")
+			if c.Breaking {
+				b.WriteString("**BREAKING:** ")
+			}
+			if c.Scope != "" {
+				fmt.Fprintf(&b, "**%s:** ", c.Scope)
+			}
+			b.WriteString(c.Description)
+			if len(c.Hash) >= 7 {
+				fmt.Fprintf(&b, " (%s)", c.Hash[:7])
+			}
+			b.WriteString("\n")
+			if c.BreakingNote != "" {
+				fmt.Fprintf(&b, "  %s\n", strings.ReplaceAll(c.BreakingNote, "\n", "\n  "))
+			}
+		}
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+// prependRelease adds entry above the newest release in changelog, after its
+// introduction. An Unreleased section stays on top, unless entry is the
+// Unreleased section, which replaces it. An empty changelog is given the Keep a
+// Changelog header.
+func prependRelease(changelog, entry string) string {
+	if strings.TrimSpace(changelog) == "" {
+		return changelogHeader + "\n" + entry
+	}
+	start := strings.Index("\n"+changelog, "\n## ")
+	if start < 0 {
+		return strings.TrimRight(changelog, "\n") + "\n\n" + entry
+	}
+	end := len(changelog)
+	if i := strings.Index(changelog[start+1:], "\n## "); i >= 0 {
+		end = start + 1 + i + 1
+	}
+	if releaseVersion(changelog[start:]) == "Unreleased" {
+		if releaseVersion(entry) == "Unreleased" {
+			return changelog[:start] + entry + changelog[end:]
+		}
+		section := changelog[start:end]
+		if end == len(changelog) {
+			section = strings.TrimRight(section, "\n") + "\n\n"
+		}
+		return changelog[:start] + section + entry + changelog[end:]
+	}
+	return changelog[:start] + entry + changelog[start:]
+}
+
+// releaseVersion returns the version in the release heading at the start of
+// s, such as v1.0.0 in "## [v1.0.0] - 2024-06-01".
+func releaseVersion(s string) string {
+	heading, _, _ := strings.Cut(strings.TrimPrefix(s, "## "), "\n")
+	version, _, _ := strings.Cut(heading, " ")
+	return strings.Trim(version, "[]")
+}
+
+// summariseRelease asks each model in turn for a summary of the release.
+func summariseRelease(ctx context.Context, r release) (string, error) {
+	prompt := redactPrompt(r.String())
+	content := fmt.Sprintf(changelogPromptData, r.Version, prompt.Parts[0], prompt.Note())
+	gen, err := prompt.ask(ctx, func(model modelSpec) (messageResponse, error) {
+		return sendPrompt(ctx, model, content, false)
+	})
+	if err != nil {
+		return "", err
+	}
+	summary, ok := tagContent(gen.Response.Text(), "summary")
+	if !ok {
+		return "", &stageError{Stage: stageAPI, Err: errors.New("the response has no <summary> section")}
+	}
+	return summary, nil
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func Test_release_String(t *testing.T) {
+	commits := []conventionalCommit{
+		{Hash: "1111111aaaa", Type: "feat", Scope: "web", Description: "add dark mode"},
+		{Hash: "2222222bbbb", Type: "fix", Description: "handle empty input"},
+		{Hash: "3333333cccc", Type: "feat", Scope: "api", Description: "add users"},
+		{Hash: "4444444dddd", Type: "docs", Description: "fix typo"},
+		{Hash: "5555555eeee", Type: "chore", Scope: "config", Description: "move the config file",
+			Breaking: true, BreakingNote: "Move config.yml\nto ~/.config."},
+		{Hash: "6666666ffff", Description: "Update things"},
+	}
+	rel := release{Version: "v1.1.0", Date: "2024-05-01", Summary: "Users and dark mode.", Entries: groupChangelog(commits, false)}
+	want := `## [v1.1.0] - 2024-05-01
+
+Users and dark mode.
+
+### Added
+
+- **api:** add users (3333333)
+- **web:** add dark mode (1111111)
+
+### Changed
+
+- **BREAKING:** **config:** move the config file (5555555)
+  Move config.yml
+  to ~/.config.
+
+### Fixed
+
+- handle empty input (2222222)
+
+`
+	if diff := cmp.Diff(want, rel.String()); diff != "" {
+		t.Errorf("String() mismatch (-want +got):\n%s", diff)
+	}
+
+	all := groupChangelog(commits, true)
+	if got := len(all["Changed"]); got != 3 {
+		t.Errorf("groupChangelog(all) Changed = %d entries, want 3", got)
+	}
+}
+
+func Test_prependRelease(t *testing.T) {
+	entry := "## [v2] - 2024-06-01\n\n### Fixed\n\n- b\n\n"
+	tests := []struct {
+		name      string
+		changelog string
+		entry     string
+		want      string
+	}{
+		{
+			name:      "new file",
+			changelog: "",
+			want:      changelogHeader + "\n" + entry,
+		},
+		{
+			name:      "after the introduction",
+			changelog: "# Changelog\n\nIntro.\n\n## [v1] - 2024-01-01\n\n### Added\n\n- a\n",
+			want:      "# Changelog\n\nIntro.\n\n" + entry + "## [v1] - 2024-01-01\n\n### Added\n\n- a\n",
+		},
+		{
+			name:      "no introduction",
+			changelog: "## [v1]\n",
+			want:      entry + "## [v1]\n",
+		},
+		{
+			name:      "below unreleased",
+			changelog: "# Changelog\n\n## [Unreleased]\n\n- c\n\n## [v1] - 2024-01-01\n\n- a\n",
+			want:      "# Changelog\n\n## [Unreleased]\n\n- c\n\n" + entry + "## [v1] - 2024-01-01\n\n- a\n",
+		},
+		{
+			name:      "below unreleased only",
+			changelog: "# Changelog\n\n## [Unreleased]\n\n- c\n",
+			want:      "# Changelog\n\n## [Unreleased]\n\n- c\n\n" + entry,
+		},
+		{
+			name:      "replaces unreleased",
+			changelog: "# Changelog\n\n## [Unreleased]\n\n- c\n\n## [v1] - 2024-01-01\n\n- a\n",
+			entry:     "## [Unreleased]\n\n- d\n\n",
+			want:      "# Changelog\n\n## [Unreleased]\n\n- d\n\n## [v1] - 2024-01-01\n\n- a\n",
+		},
+		{
+			name:      "no releases",
+			changelog: "# Changelog\n",
+			want:      "# Changelog\n\n" + entry,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.entry == "" {
+				tt.entry = entry
+			}
+			if diff := cmp.Diff(tt.want, prependRelease(tt.changelog, tt.entry)); diff != "" {
+				t.Errorf("prependRelease() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func Test_runChangelog(t *testing.T) {
+	prompt := fakeAPI(t, "<summary>\nAdds b.\n</summary>")
+	ctx := context.Background()
+	chdirTempRepo(t)
+	stageFiles(t, map[string]string{"a": "a"})
+	commitStaged(t, "feat: a")
+	exec.Command("git", "tag", "v1.0.0").Run()
+	stageFiles(t, map[string]string{"b": "b"})
+	commitStaged(t, "feat(b): add b")
+	exec.Command("git", "tag", "v1.1.0").Run()
+	stageFiles(t, map[string]string{"c": "c"})
+	commitStaged(t, "fix: c")
+
+	if err := runChangelog(ctx, "v1.0.0..v1.1.0", "", true, false, "CHANGELOG.md"); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(*prompt, "<version>v1.1.0</version>") || !strings.Contains(*prompt, "**b:** add b") {
+		t.Errorf("prompt = %q, want the release", *prompt)
+	}
+	if strings.Contains(*prompt, "REDACTED-") {
+		t.Errorf("prompt explains placeholders when nothing was redacted:\n%s", *prompt)
+	}
+	if err := runChangelog(ctx, "", "", false, false, "CHANGELOG.md"); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile("CHANGELOG.md")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := string(data)
+	for _, want := range []string{
+		"## [Unreleased]\n\n### Fixed\n\n- c (",
+		"## [v1.1.0] - ",
+		"Adds b.\n\n### Added\n\n- **b:** add b (",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("CHANGELOG.md missing %q:\n%s", want, got)
+		}
+	}
+	if strings.Index(got, "[Unreleased]") > strings.Index(got, "[v1.1.0]") {
+		t.Errorf("the newest release is not first:\n%s", got)
+	}
+	if strings.Contains(got, "- a (") {
+		t.Errorf("CHANGELOG.md contains a commit before the range:\n%s", got)
+	}
+
+	if err := runChangelog(ctx, "v1.0.0", "", false, false, ""); err == nil {
+		t.Error("runChangelog() accepted a revision that is not a range")
+	}
+}
@@ -0,0 +1,285 @@
+package main
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"encoding/xml"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+)
+
+//go:embed check-prompt.txt
+var checkPromptData string
+
+// CheckThreshold is the least severe finding that makes commitgpt check fail.
+var CheckThreshold = "error"
+
+// checkedCommit is a commit of the range and what was found in its message.
+type checkedCommit struct {
+	Hash     string    `json:"hash"`
+	Subject  string    `json:"subject"`
+	Findings []finding `json:"findings"`
+	message  string
+	parent   string
+}
+
+// checkReport is the result of commitgpt check.
+type checkReport struct {
+	Range     string          `json:"range"`
+	Threshold severity        `json:"threshold"`
+	Reviewed  bool            `json:"reviewed"`
+	Failed    bool            `json:"failed"`
+	Commits   []checkedCommit `json:"commits"`
+}
+
+// checkFormats write the report in each output format.
+var checkFormats = map[string]func(w io.Writer, r checkReport) error{
+	"text":   writeCheckText,
+	"json":   writeCheckJSON,
+	"junit":  writeCheckJUnit,
+	"github": writeCheckGitHub,
+}
+
+// checkCommand lints the messages of the commits in a range, for CI. It exits
+// with 1 if a finding is at least as severe as the threshold, and 2 if the
+// check could not be run.
+func checkCommand(ctx context.Context, config gitConfig, args []string) int {
+	flags := flag.NewFlagSet("commitgpt check", flag.ContinueOnError)
+	format := flags.String("format", "", "the output `format`: text, json, junit or github (default github on GitHub Actions, otherwise text)")
+	output := flags.String("output", "", "write the report to `file` instead of stdout")
+	review := flags.Bool("review", false, "also ask the model whether each message describes its diff")
+	failOn := flags.String("fail-on", CheckThreshold, "the least `severity` that fails the check: info, warning or error")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "usage: commitgpt check [--review] [--format <format>] [--fail-on <severity>] <base>..<head>")
+		return 2
+	}
+	if *format == "" {
+		*format = "text"
+		if os.Getenv("GITHUB_ACTIONS") == "true" {
+			*format = "github"
+		}
+	}
+	write, ok := checkFormats[*format]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "commitgpt check: unknown format %q: want text, json, junit or github\n", *format)
+		return 2
+	}
+	threshold, err := parseSeverity(*failOn)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "commitgpt check:", err)
+		return 2
+	}
+
+	report, err := runCheck(ctx, flags.Arg(0), *review, threshold)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "commitgpt check:", err)
+		return 2
+	}
+	w := io.Writer(os.Stdout)
+	if *output != "" && *output != "-" {
+		file, err := os.Create(*output)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "commitgpt check:", err)
+			return 2
+		}
+		defer file.Close()
+		w = file
+	}
+	if err := write(w, report); err != nil {
+		fmt.Fprintln(os.Stderr, "commitgpt check:", err)
+		return 2
+	}
+	if report.Failed {
+		return 1
+	}
+	return 0
+}
+
+// runCheck lints the messages of the commits in rev, and with review, asks
+// the model whether they describe their diffs. Merges are skipped.
+func runCheck(ctx context.Context, rev string, review bool, threshold severity) (checkReport, error) {
+	report := checkReport{Range: rev, Threshold: threshold, Reviewed: review, Commits: []checkedCommit{}}
+	if !strings.Contains(rev, "..") {
+		return report, fmt.Errorf("%q is not a range like origin/main..HEAD", rev)
+	}
+	from, to, err := parseRevRange(ctx, rev)
+	if err != nil {
+		return report, err
+	}
+	out, err := git(ctx, "log", "-z", "--reverse", "--no-merges", "--format=%H%x1f%P%x1f%B", revisionRange(from, to), "--")
+	if err != nil {
+		return report, err
+	}
+	for _, entry := range splitNUL(out) {
+		fields := strings.SplitN(entry, "\x1f", 3)
+		if len(fields) != 3 {
+			continue
+		}
+		c := checkedCommit{Hash: fields[0], message: strings.TrimSpace(fields[2]), Findings: []finding{}}
+		c.Subject, _, _ = strings.Cut(c.message, "\n")
+		if parents := strings.Fields(fields[1]); len(parents) > 0 {
+			c.parent = parents[0]
+		}
+		c.Findings = append(c.Findings, lintMessage(c.message)...)
+		report.Commits = append(report.Commits, c)
+	}
+
+	if review {
+		for i := range report.Commits {
+			findings, err := reviewMessage(ctx, report.Commits[i])
+			if err != nil {
+				return report, fmt.Errorf("%s: %w", report.Commits[i].Hash[:7], err)
+			}
+			report.Commits[i].Findings = append(report.Commits[i].Findings, findings...)
+		}
+	}
+	for _, c := range report.Commits {
+		for _, f := range c.Findings {
+			if f.Severity >= threshold {
+				report.Failed = true
+			}
+		}
+	}
+	return report, nil
+}
+
+var reviewFindingPattern = regexp.MustCompile(`(?s)<finding severity="(\w+)">(.*?)</finding>`)
+
+// reviewMessage asks each model in turn whether the message of c describes
+// its diff. A review without a verdict is a warning.
+func reviewMessage(ctx context.Context, c checkedCommit) ([]finding, error) {
+	base := c.parent
+	if base == "" {
+		base = emptyTree
+	}
+	diff, err := rangeDiff(ctx, revRange{Base: base, Head: c.Hash})
+	if err != nil {
+		return nil, err
+	}
+	prompt := redactPrompt(c.message, diff)
+	content := fmt.Sprintf(checkPromptData, prompt.Parts[0], prompt.Parts[1], prompt.Note())
+	gen, err := prompt.ask(ctx, func(model modelSpec) (messageResponse, error) {
+		return sendPrompt(ctx, model, content, false)
+	})
+	if err != nil {
+		return nil, err
+	}
+	text := gen.Response.Text()
+	var findings []finding
+	for _, m := range reviewFindingPattern.FindAllStringSubmatch(text, -1) {
+		sev, err := parseSeverity(m[1])
+		if err != nil {
+			sev = severityWarning
+		}
+		findings = append(findings, finding{Rule: "review", Severity: sev, Message: strings.TrimSpace(m[2])})
+	}
+	if findings == nil && !strings.Contains(text, "<accurate/>") {
+		// The model did not follow the format, which is no reason to stop
+		// reviewing the other commits.
+		findings = append(findings, finding{Rule: "review", Severity: severityWarning,
+			Message: "the review has neither findings nor <accurate/>, so the message was not checked"})
+	}
+	return findings, nil
+}
+
+func writeCheckText(w io.Writer, r checkReport) error {
+	var count int
+	for _, c := range r.Commits {
+		for _, f := range c.Findings {
+			count++
+			if _, err := fmt.Fprintf(w, "%s %s: %s: %s [%s]\n", c.Hash[:7], c.Subject, f.Severity, f.Message, f.Rule); err != nil {
+				return err
+			}
+		}
+	}
+	status := "passed"
+	if r.Failed {
+		status = "failed"
+	}
+	_, err := fmt.Fprintf(w, "%d commits, %d findings: %s (threshold %s)\n", len(r.Commits), count, status, r.Threshold)
+	return err
+}
+
+func writeCheckJSON(w io.Writer, r checkReport) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
+
+// writeCheckJUnit writes a test case for each commit. Findings at or above
+// the threshold are failures, and the others are in its output.
+func writeCheckJUnit(w io.Writer, r checkReport) error {
+	type failure struct {
+		Type    string `xml:"type,attr"`
+		Message string `xml:"message,attr"`
+		Text    string `xml:",chardata"`
+	}
+	type testCase struct {
+		Name      string    `xml:"name,attr"`
+		ClassName string    `xml:"classname,attr"`
+		Failures  []failure `xml:"failure"`
+		SystemOut string    `xml:"system-out,omitempty"`
+	}
+	type testSuite struct {
+		Name     string     `xml:"name,attr"`
+		Tests    int        `xml:"tests,attr"`
+		Failures int        `xml:"failures,attr"`
+		Cases    []testCase `xml:"testcase"`
+	}
+	suite := testSuite{Name: "commitgpt check " + r.Range, Tests: len(r.Commits)}
+	for _, c := range r.Commits {
+		tc := testCase{Name: c.Hash[:7] + " " + c.Subject, ClassName: "commitgpt.check"}
+		var out strings.Builder
+		for _, f := range c.Findings {
+			if f.Severity >= r.Threshold {
+				tc.Failures = append(tc.Failures, failure{Type: f.Rule, Message: f.Message, Text: f.Severity.String() + ": " + f.Message})
+			} else {
+				fmt.Fprintf(&out, "%s: %s [%s]\n", f.Severity, f.Message, f.Rule)
+			}
+		}
+		tc.SystemOut = out.String()
+		if len(tc.Failures) > 0 {
+			suite.Failures++
+		}
+		suite.Cases = append(suite.Cases, tc)
+	}
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(struct {
+		XMLName xml.Name    `xml:"testsuites"`
+		Suites  []testSuite `xml:"testsuite"`
+	}{Suites: []testSuite{suite}}); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+// writeCheckGitHub writes workflow commands that GitHub Actions shows as
+// annotations.
+func writeCheckGitHub(w io.Writer, r checkReport) error {
+	levels := map[severity]string{severityInfo: "notice", severityWarning: "warning", severityError: "error"}
+	escape := strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A")
+	escapeProperty := strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A", ":", "%3A", ",", "%2C")
+	for _, c := range r.Commits {
+		for _, f := range c.Findings {
+			title := fmt.Sprintf("commitgpt %s in %s", f.Rule, c.Hash[:7])
+			message := fmt.Sprintf("%s: %s", c.Subject, f.Message)
+			if _, err := fmt.Fprintf(w, "::%s title=%s::%s\n", levels[f.Severity], escapeProperty.Replace(title), escape.Replace(message)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+// checkTestRepo makes a repository with a good commit on main and two commits
+// on a branch after it, and returns the range of the branch.
+func checkTestRepo(t *testing.T) string {
+	chdirTempRepo(t)
+	exec.Command("git", "checkout", "-q", "-b", "main").Run()
+	stageFiles(t, map[string]string{"a": "a"})
+	commitStaged(t, "wip")
+	exec.Command("git", "checkout", "-q", "-b", "feature").Run()
+	stageFiles(t, map[string]string{"b": "b"})
+	commitStaged(t, "feat: add b")
+	stageFiles(t, map[string]string{"c": "c"})
+	commitStaged(t, "fix: add c.")
+	return "main..feature"
+}
+
+func Test_runCheck(t *testing.T) {
+	ctx := context.Background()
+	rev := checkTestRepo(t)
+
+	report, err := runCheck(ctx, rev, false, severityError)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Commits) != 2 || report.Commits[0].Subject != "feat: add b" || len(report.Commits[0].Findings) != 0 {
+		t.Fatalf("runCheck() = %+v", report)
+	}
+	if f := report.Commits[1].Findings; len(f) != 1 || f[0].Rule != "subject-period" {
+		t.Errorf("runCheck() findings = %+v, want subject-period", f)
+	}
+	if report.Failed {
+		t.Error("runCheck() failed on a warning with an error threshold")
+	}
+	if report, _ = runCheck(ctx, rev, false, severityWarning); !report.Failed {
+		t.Error("runCheck() passed a warning with a warning threshold")
+	}
+	if _, err := runCheck(ctx, "feature", false, severityError); err == nil {
+		t.Error("runCheck() accepted a revision that is not a range")
+	}
+}
+
+func Test_runCheck_review(t *testing.T) {
+	prompt := fakeAPI(t, "<finding severity=\"error\">The diff adds c, not a fix.</finding>\n<finding severity=\"loud\">Odd.</finding>")
+	ctx := context.Background()
+	rev := checkTestRepo(t)
+
+	report, err := runCheck(ctx, rev, true, severityError)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(*prompt, "<commit-message>\nfix: add c.\n</commit-message>") || !strings.Contains(*prompt, "+c") {
+		t.Errorf("prompt = %q, want the message and its diff", *prompt)
+	}
+	if strings.Contains(*prompt, "REDACTED-") {
+		t.Errorf("prompt explains placeholders when nothing was redacted:\n%s", *prompt)
+	}
+	f := report.Commits[1].Findings
+	if len(f) != 3 || f[1].Rule != "review" || f[1].Severity != severityError || f[2].Severity != severityWarning {
+		t.Errorf("runCheck() findings = %+v", f)
+	}
+	if !report.Failed {
+		t.Error("runCheck() passed an error from the review")
+	}
+}
+
+func Test_runCheck_reviewWithoutVerdict(t *testing.T) {
+	fakeAPI(t, "The message looks fine to me.")
+	ctx := context.Background()
+	rev := checkTestRepo(t)
+
+	report, err := runCheck(ctx, rev, true, severityError)
+	if err != nil {
+		t.Fatalf("runCheck() err = %v, want a warning", err)
+	}
+	for _, c := range report.Commits {
+		f := c.Findings[len(c.Findings)-1]
+		if f.Rule != "review" || f.Severity != severityWarning || !strings.Contains(f.Message, "<accurate/>") {
+			t.Errorf("runCheck() %s findings = %+v, want a review warning", c.Hash[:7], c.Findings)
+		}
+	}
+	if report.Failed {
+		t.Error("runCheck() failed on a warning")
+	}
+}
+
+func Test_writeCheck(t *testing.T) {
+	report := checkReport{
+		Range:     "main..feature",
+		Threshold: severityWarning,
+		Failed:    true,
+		Commits: []checkedCommit{
+			{Hash: "1111111aaaa", Subject: "feat: add b", Findings: []finding{}},
+			{Hash: "2222222bbbb", Subject: "fix: add c.", Findings: []finding{
+				{Rule: "subject-period", Severity: severityWarning, Line: 1, Message: "the subject line ends with a period"},
+				{Rule: "review", Severity: severityInfo, Message: "Could say why,\nand 100%."},
+			}},
+		},
+	}
+
+	var out bytes.Buffer
+	if err := writeCheckJSON(&out, report); err != nil {
+		t.Fatal(err)
+	}
+	var decoded struct {
+		Failed    bool   `json:"failed"`
+		Threshold string `json:"threshold"`
+		Commits   []struct {
+			Findings []struct {
+				Severity string `json:"severity"`
+			} `json:"findings"`
+		} `json:"commits"`
+	}
+	if err := json.Unmarshal(out.Bytes(), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if !decoded.Failed || decoded.Threshold != "warning" || decoded.Commits[1].Findings[0].Severity != "warning" {
+		t.Errorf("writeCheckJSON() = %s", out.String())
+	}
+
+	out.Reset()
+	if err := writeCheckJUnit(&out, report); err != nil {
+		t.Fatal(err)
+	}
+	var junit struct {
+		Suites []struct {
+			Tests    int `xml:"tests,attr"`
+			Failures int `xml:"failures,attr"`
+			Cases    []struct {
+				Name     string `xml:"name,attr"`
+				Failures []struct {
+					Type string `xml:"type,attr"`
+				} `xml:"failure"`
+				SystemOut string `xml:"system-out"`
+			} `xml:"testcase"`
+		} `xml:"testsuite"`
+	}
+	if err := xml.Unmarshal(out.Bytes(), &junit); err != nil {
+		t.Fatal(err)
+	}
+	suite := junit.Suites[0]
+	if suite.Tests != 2 || suite.Failures != 1 || suite.Cases[1].Name != "2222222 fix: add c." ||
+		len(suite.Cases[1].Failures) != 1 || suite.Cases[1].Failures[0].Type != "subject-period" ||
+		!strings.Contains(suite.Cases[1].SystemOut, "info: Could say why") {
+		t.Errorf("writeCheckJUnit() = %s", out.String())
+	}
+
+	out.Reset()
+	if err := writeCheckGitHub(&out, report); err != nil {
+		t.Fatal(err)
+	}
+	want := "::warning title=commitgpt subject-period in 2222222::fix: add c.: the subject line ends with a period\n" +
+		"::notice title=commitgpt review in 2222222::fix: add c.: Could say why,%0Aand 100%25.\n"
+	if out.String() != want {
+		t.Errorf("writeCheckGitHub() = %q, want %q", out.String(), want)
+	}
+
+	out.Reset()
+	if err := writeCheckText(&out, report); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(out.String(), "2 commits, 2 findings: failed (threshold warning)\n") {
+		t.Errorf("writeCheckText() = %q", out.String())
+	}
+}
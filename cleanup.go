@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// CommentChar is git's core.commentChar, which starts the comment lines of the
+// commit message file. With "auto", git picks a character that no line of the
+// message starts with; see commentCharFor.
+var CommentChar = "#"
+
+// Cleanup is git's commit.cleanup, which decides whether comment lines are
+// stripped from the commit message.
+var Cleanup = "default"
+
+// cutLine follows the comment character on the scissors line, below which
+// git ignores the commit message file.
+const cutLine = "------------------------ >8 ------------------------"
+
+// autoCommentChars are the characters that core.commentChar=auto picks from,
+// in git's order.
+const autoCommentChars = "#;@!$%^&|:"
+
+// loadCommitConfig reads the git settings that decide how the commit message
+// file is cleaned up.
+func loadCommitConfig(ctx context.Context) error {
+	out, err := exec.CommandContext(ctx, "git", "config", "-z", "--show-origin", "--get-regexp", `^(core\.commentchar|commit\.cleanup)$`).Output()
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && exitErr.ExitCode() == 1 {
+			return nil
+		}
+		return fmt.Errorf("git config: %w", err)
+	}
+	c := parseGitConfig(out)
+	CommentChar = c.String("core.commentChar", "", CommentChar)
+	if CommentChar == "" {
+		CommentChar = "#"
+	}
+	Cleanup = c.String("commit.cleanup", "", Cleanup)
+	return nil
+}
+
+// cleanupKeepsComments reports whether git keeps comment lines in the commit
+// message. With scissors, git only cuts the message at the scissors line, and
+// keeps the comments above it.
+func cleanupKeepsComments() bool {
+	return Cleanup == "whitespace" || Cleanup == "verbatim" || Cleanup == "scissors"
+}
+
+// scissorsLine returns the line below which git ignores the commit message
+// file.
+func scissorsLine() string {
+	return CommentChar + " " + cutLine
+}
+
+// commentCharFor returns the comment character of content, a commit message
+// file from git. Only core.commentChar=auto needs content.
+func commentCharFor(content string) string {
+	if CommentChar != "auto" {
+		return CommentChar
+	}
+	lines := strings.Split(content, "\n")
+	// The scissors line of git commit -v is the surest sign.
+	for _, line := range lines {
+		if c, ok := strings.CutSuffix(line, " "+cutLine); ok && len(c) == 1 && strings.Contains(autoCommentChars, c) {
+			return c
+		}
+	}
+	// Otherwise git's comments end the file, after the message.
+	for i := len(lines) - 1; i >= 0; i-- {
+		if lines[i] == "" {
+			continue
+		}
+		if strings.ContainsRune(autoCommentChars, rune(lines[i][0])) {
+			return lines[i][:1]
+		}
+		break
+	}
+	// Without comments, the file is just the message, so pick the character
+	// as git did: # unless the message has one, or else the first that does
+	// not start a line.
+	if !strings.Contains(content, "#") {
+		return "#"
+	}
+	used := map[byte]bool{'#': true}
+	for _, line := range lines {
+		if line != "" {
+			used[line[0]] = true
+		}
+	}
+	for i := 0; i < len(autoCommentChars); i++ {
+		if !used[autoCommentChars[i]] {
+			return autoCommentChars[i : i+1]
+		}
+	}
+	return "#"
+}
+
+// renderMessageOnly formats the generated message for a commit message file
+// whose comments git keeps. The warnings are written to stderr instead.
+func renderMessageOnly(ctx context.Context, gen generation) (string, error) {
+	sensitiveWarn, largeFilesWarn, _, commitMessage := extractMessages(gen.Response.Text())
+	for _, warning := range []string{sensitiveWarn, largeFilesWarn} {
+		if warning != "" {
+			fmt.Fprintf(os.Stderr, "commitgpt: %s\n", warning)
+		}
+	}
+	if commitMessage == "" {
+		return "", nil
+	}
+	message, err := formatPlain(ctx, commitMessage)
+	if err != nil {
+		return "", &stageError{Stage: stageFormat, Err: err}
+	}
+	return strings.TrimRight(message, "\n") + "\n", nil
+}
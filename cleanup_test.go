@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// gitTemplate returns the commit message file that git gives the editor for
+// git commit with args in the current repository, without committing.
+func gitTemplate(t *testing.T, env []string, args ...string) string {
+	file := filepath.Join(t.TempDir(), "COMMIT_EDITMSG")
+	cmd := exec.Command("git", append([]string{"-c", "user.name=a", "-c", "user.email=a@example.com", "commit"}, args...)...)
+	cmd.Env = append(append(os.Environ(), `GIT_EDITOR=f() { cp "$1" '`+file+`'; exit 1; }; f`), env...)
+	cmd.Run()
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatalf("git commit %s did not run the editor: %v", strings.Join(args, " "), err)
+	}
+	return string(data)
+}
+
+func setCommentChar(t *testing.T, c, cleanup string) {
+	oldChar, oldCleanup := CommentChar, Cleanup
+	t.Cleanup(func() { CommentChar, Cleanup = oldChar, oldCleanup })
+	CommentChar, Cleanup = c, cleanup
+}
+
+func Test_commentCharFor(t *testing.T) {
+	tests := []struct {
+		name        string
+		commentChar string
+		content     string
+		want        string
+	}{
+		{name: "set", commentChar: ";", content: "\n# On branch main\n", want: ";"},
+		{name: "auto scissors", commentChar: "auto", content: "# heading\n\n; ------------------------ >8 ------------------------\ndiff\n", want: ";"},
+		{name: "auto comments at the end", commentChar: "auto", content: "# heading\n\n@ Please enter\n@\n", want: "@"},
+		{name: "auto without comments", commentChar: "auto", content: "fix: a\n", want: "#"},
+		{name: "auto without comments, with #", commentChar: "auto", content: "# heading\n; a\nfix: #1\n", want: "@"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setCommentChar(t, tt.commentChar, "default")
+			if got := commentCharFor(tt.content); got != tt.want {
+				t.Errorf("commentCharFor() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_commentCharFor_git(t *testing.T) {
+	chdirTempRepo(t)
+	stageFiles(t, map[string]string{"a.go": "package a\n"})
+	if err := os.WriteFile(".git/template.txt", []byte("# Summary\n\n; Details\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	exec.Command("git", "config", "core.commentChar", "auto").Run()
+	setCommentChar(t, "auto", "default")
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{nil, "#"},
+		{[]string{"-v"}, "#"},
+		{[]string{"-t", ".git/template.txt"}, "@"},
+		{[]string{"-v", "-t", ".git/template.txt"}, "@"},
+	}
+	for _, tt := range tests {
+		t.Run(strings.Join(tt.args, " "), func(t *testing.T) {
+			content := gitTemplate(t, []string{"LC_ALL=C"}, tt.args...)
+			if !strings.Contains(content, "\n"+tt.want+" Please enter the commit message") {
+				t.Fatalf("git chose another comment character than %q:\n%s", tt.want, content)
+			}
+			if got := commentCharFor(content); got != tt.want {
+				t.Errorf("commentCharFor() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_renderResponse_commentChar(t *testing.T) {
+	fakePandoc(t)
+	setCommentChar(t, ";", "default")
+	gen := generation{Model: modelSpec{Name: "claude-3-haiku-20240307"}}
+	gen.Response.Content = []contentBlock{{Type: "text", Text: "<sensitive-info-warning>\n- a.go, line 3: key\n</sensitive-info-warning>\n" +
+		"<thinkthrough>\nIt fixes a bug.\n</thinkthrough>\n<commit-message>\nfix: a bug\n\n# Not a comment\n</commit-message>"}}
+	response, err := renderResponse(context.Background(), gen)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(response, "\n; ------------------------ >8 ------------------------\n; Do not modify") {
+		t.Errorf("renderResponse() has no scissors line for ;:\n%s", response)
+	}
+	if plan := (&splitPlan{Groups: []splitGroup{{Subject: "fix: a"}}}).comment(); !strings.HasPrefix(plan, "; commitgpt:") || strings.Contains(plan, "\n#") {
+		t.Errorf("splitPlan.comment() = %q, want ; comments", plan)
+	}
+
+	// git strips the comments above the scissors line, and everything below.
+	above, _, _ := strings.Cut(response, "\n"+scissorsLine())
+	cmd := exec.Command("git", "-c", "core.commentChar=;", "stripspace", "--strip-comments")
+	cmd.Stdin = strings.NewReader(above)
+	out, err := cmd.Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(out), "fix: a bug\n\n# Not a comment\n"; got != want {
+		t.Errorf("the commit message would be %q, want %q", got, want)
+	}
+}
+
+func Test_handleVerboseContent_commentChar(t *testing.T) {
+	chdirTempRepo(t)
+	stageFiles(t, map[string]string{"a.go": "package a\n"})
+	exec.Command("git", "config", "core.commentChar", ";").Run()
+	setCommentChar(t, ";", "default")
+	content := gitTemplate(t, []string{"LC_ALL=C"}, "-v")
+	got := handleVerboseContent(content)
+	if !strings.HasPrefix(got, "; On branch") || !strings.Contains(got, "\ndiff --git a/a.go b/a.go\n") ||
+		strings.Contains(got, "Please enter") || strings.Contains(got, cutLine) {
+		t.Errorf("handleVerboseContent() = %q", got)
+	}
+	if got := annotateTemplate(content, "note"); !strings.HasPrefix(got, "\n; note\n; Please enter") {
+		t.Errorf("annotateTemplate() = %q", got)
+	}
+}
+
+func Test_runHook_cleanupKeepsComments(t *testing.T) {
+	tests := []struct {
+		cleanup string
+		content string
+	}{
+		{
+			cleanup: "whitespace",
+			content: "\n# Please enter the commit message for your changes. Lines starting\n# with '#' will be kept; you may remove them yourself if you want to.\n#\n",
+		},
+		{
+			cleanup: "verbatim",
+			content: "\n",
+		},
+		{
+			// git keeps the comments above the scissors line.
+			cleanup: "scissors",
+			content: "\n# ------------------------ >8 ------------------------\n# Do not modify or remove the line above.\n# Everything below it will be ignored.\n#\n# On branch master\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.cleanup, func(t *testing.T) {
+			chdirTempRepo(t)
+			stageFiles(t, map[string]string{"README": "a\n"})
+			commitStaged(t, "docs: add a readme")
+			stageFiles(t, map[string]string{"a.go": "package a\n"})
+			fakePandoc(t)
+			fakeAPI(t, "<sensitive-info-warning>\n- a.go: a key\n</sensitive-info-warning>\n<commit-message>\nfeat: add a\n</commit-message>")
+			setCommentChar(t, "#", tt.cleanup)
+			file := filepath.Join(t.TempDir(), "COMMIT_EDITMSG")
+			if err := os.WriteFile(file, []byte(tt.content), 0644); err != nil {
+				t.Fatal(err)
+			}
+			if err := runHook(context.Background(), file); err != nil {
+				t.Fatal(err)
+			}
+			got, err := os.ReadFile(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := "feat: add a\n\n" + tt.content[1:]; string(got) != want {
+				t.Errorf("runHook() wrote %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func Test_loadCommitConfig(t *testing.T) {
+	chdirTempRepo(t)
+	setCommentChar(t, "#", "default")
+	if err := loadCommitConfig(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if CommentChar != "#" || Cleanup != "default" {
+		t.Errorf("loadCommitConfig() = %q, %q, want the defaults", CommentChar, Cleanup)
+	}
+	exec.Command("git", "config", "core.commentChar", ";").Run()
+	exec.Command("git", "config", "commit.cleanup", "whitespace").Run()
+	if err := loadCommitConfig(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if CommentChar != ";" || Cleanup != "whitespace" || !cleanupKeepsComments() {
+		t.Errorf("loadCommitConfig() = %q, %q, want ;, whitespace", CommentChar, Cleanup)
+	}
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// configEntry is a single commitgpt.* value read from git config, along with
+// the file it came from.
+type configEntry struct {
+	Value  string
+	Origin string
+}
+
+// gitConfig holds every commitgpt.* setting, keyed by canonical name (see
+// canonicalKey). Multi-valued keys keep their values in the order git reports
+// them.
+type gitConfig map[string][]configEntry
+
+func loadGitConfig(ctx context.Context) (gitConfig, error) {
+	out, err := exec.CommandContext(ctx, "git", "config", "-z", "--show-origin", "--get-regexp", `^commitgpt\.`).Output()
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && exitErr.ExitCode() == 1 {
+			// No matching keys.
+			return gitConfig{}, nil
+		}
+		return nil, fmt.Errorf("git config: %w", err)
+	}
+	return parseGitConfig(out), nil
+}
+
+func parseGitConfig(data []byte) gitConfig {
+	config := gitConfig{}
+	fields := bytes.Split(data, []byte{0})
+	for i := 0; i+1 < len(fields); i += 2 {
+		origin := string(fields[i])
+		key, value, _ := strings.Cut(string(fields[i+1]), "\n")
+		key = canonicalKey(key)
+		config[key] = append(config[key], configEntry{Value: value, Origin: origin})
+	}
+	return config
+}
+
+// canonicalKey lower-cases the section and variable name of key, as git does.
+// Subsection names are case sensitive and are left alone.
+func canonicalKey(key string) string {
+	i, j := strings.Index(key, "."), strings.LastIndex(key, ".")
+	if i < 0 {
+		return strings.ToLower(key)
+	}
+	return strings.ToLower(key[:i]) + key[i:j] + strings.ToLower(key[j:])
+}
+
+// lookup returns the value of env, if set, otherwise the last value of key.
+func (c gitConfig) lookup(key, env string) (string, bool) {
+	if env != "" {
+		if v, ok := os.LookupEnv(env); ok {
+			return v, true
+		}
+	}
+	entries := c[canonicalKey(key)]
+	if len(entries) == 0 {
+		return "", false
+	}
+	return entries[len(entries)-1].Value, true
+}
+
+func (c gitConfig) String(key, env, def string) string {
+	if v, ok := c.lookup(key, env); ok {
+		return v
+	}
+	return def
+}
+
+// Strings returns every value of key, or the comma separated values of env
+// when it is set.
+func (c gitConfig) Strings(key, env string, def []string) []string {
+	if env != "" {
+		if v, ok := os.LookupEnv(env); ok {
+			return strings.Split(v, ",")
+		}
+	}
+	entries := c[canonicalKey(key)]
+	if len(entries) == 0 {
+		return def
+	}
+	values := make([]string, len(entries))
+	for i, entry := range entries {
+		values[i] = entry.Value
+	}
+	return values
+}
+
+func (c gitConfig) Int(key, env string, def int) (int, error) {
+	v, ok := c.lookup(key, env)
+	if !ok {
+		return def, nil
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(v))
+	if err != nil {
+		return def, fmt.Errorf("%s: %w", key, err)
+	}
+	return n, nil
+}
+
+func (c gitConfig) Bool(key, env string, def bool) (bool, error) {
+	v, ok := c.lookup(key, env)
+	if !ok {
+		return def, nil
+	}
+	switch strings.ToLower(strings.TrimSpace(v)) {
+	case "", "true", "yes", "on", "1":
+		// A key without a value is true, as in git.
+		return true, nil
+	case "false", "no", "off", "0":
+		return false, nil
+	}
+	return def, fmt.Errorf("%s: invalid boolean %q", key, v)
+}
+
+func (c gitConfig) Duration(key, env string, def time.Duration) (time.Duration, error) {
+	v, ok := c.lookup(key, env)
+	if !ok {
+		return def, nil
+	}
+	d, err := time.ParseDuration(strings.TrimSpace(v))
+	if err != nil {
+		return def, fmt.Errorf("%s: %w", key, err)
+	}
+	return d, nil
+}
+
+// applyConfig overrides the package defaults with any configured values.
+func applyConfig(c gitConfig) (err error) {
+	if err = loadFailurePolicies(c); err != nil {
+		return
+	}
+	Providers = loadProviders(c)
+	if names := c.Strings("commitgpt.model", "COMMITGPT_MODEL", nil); len(names) > 0 {
+		Models = nil
+		for _, name := range names {
+			if name = strings.TrimSpace(name); name != "" {
+				Models = append(Models, parseModelSpec(name))
+			}
+		}
+		if len(Models) == 0 {
+			return errors.New("commitgpt.model: no model is named")
+		}
+	}
+	Proxy = c.String("commitgpt.proxy", "COMMITGPT_PROXY", Proxy)
+	CABundle = c.String("commitgpt.caBundle", "COMMITGPT_CA_BUNDLE", CABundle)
+	ClientCert = c.String("commitgpt.clientCert", "COMMITGPT_CLIENT_CERT", ClientCert)
+	ClientKey = c.String("commitgpt.clientKey", "COMMITGPT_CLIENT_KEY", ClientKey)
+	Headers = c.Strings("commitgpt.header", "", Headers)
+	Credentials.Env = c.String("commitgpt.apiKeyEnv", "", Credentials.Env)
+	Credentials.File = c.String("commitgpt.apiKeyFile", "COMMITGPT_API_KEY_FILE", Credentials.File)
+	Credentials.Helper = c.String("commitgpt.apiKeyHelper", "COMMITGPT_API_KEY_HELPER", Credentials.Helper)
+	Credentials.URL = c.String("commitgpt.credentialUrl", "COMMITGPT_CREDENTIAL_URL", Credentials.URL)
+	if Credentials.HelperTTL, err = c.Duration("commitgpt.apiKeyHelperTTL", "COMMITGPT_API_KEY_HELPER_TTL", Credentials.HelperTTL); err != nil {
+		return
+	}
+	if Redaction.Patterns, err = compileRedactPatterns(c.Strings("commitgpt.redact", "", nil)); err != nil {
+		return
+	}
+	Redaction.Hosts = c.Strings("commitgpt.redactHost", "COMMITGPT_REDACT_HOST", Redaction.Hosts)
+	if Redaction.Emails, err = c.Bool("commitgpt.redactEmails", "COMMITGPT_REDACT_EMAILS", Redaction.Emails); err != nil {
+		return
+	}
+	if Redaction.IPs, err = c.Bool("commitgpt.redactIPs", "COMMITGPT_REDACT_IPS", Redaction.IPs); err != nil {
+		return
+	}
+	if Redaction.Restore, err = c.Bool("commitgpt.redactRestore", "COMMITGPT_REDACT_RESTORE", Redaction.Restore); err != nil {
+		return
+	}
+	if Examples, err = c.Int("commitgpt.examples", "COMMITGPT_EXAMPLES", Examples); err != nil {
+		return
+	}
+	if ExamplesTokens, err = c.Int("commitgpt.examplesTokens", "COMMITGPT_EXAMPLES_TOKENS", ExamplesTokens); err != nil {
+		return
+	}
+	ExampleFilters = c.Strings("commitgpt.exampleFilter", "COMMITGPT_EXAMPLE_FILTER", ExampleFilters)
+	for _, filter := range ExampleFilters {
+		switch strings.ToLower(strings.TrimSpace(filter)) {
+		case "author", "paths", "quality", "":
+		default:
+			return fmt.Errorf("commitgpt.exampleFilter: unknown filter %q: want author, paths or quality", filter)
+		}
+	}
+	if ScopeRules, err = loadScopeRules(c); err != nil {
+		return
+	}
+	ScopeMode = strings.ToLower(c.String("commitgpt.scopeMode", "COMMITGPT_SCOPE_MODE", ScopeMode))
+	switch ScopeMode {
+	case "off", "prompt", "rewrite":
+	default:
+		return fmt.Errorf("commitgpt.scopeMode: unknown value %q: want off, prompt or rewrite", ScopeMode)
+	}
+	if MaxScopes, err = c.Int("commitgpt.maxScopes", "COMMITGPT_MAX_SCOPES", MaxScopes); err != nil {
+		return
+	}
+	Upstream = c.String("commitgpt.upstream", "COMMITGPT_UPSTREAM", Upstream)
+	BranchContext = strings.ToLower(c.String("commitgpt.branchContext", "COMMITGPT_BRANCH_CONTEXT", BranchContext))
+	switch BranchContext {
+	case "off", "subjects", "bodies":
+	default:
+		return fmt.Errorf("commitgpt.branchContext: unknown value %q: want off, subjects or bodies", BranchContext)
+	}
+	if Verbose, err = c.Bool("commitgpt.verbose", "COMMITGPT_VERBOSE", Verbose); err != nil {
+		return
+	}
+	if BlockSensitive, err = c.Bool("commitgpt.blockSensitive", "COMMITGPT_BLOCK_SENSITIVE", BlockSensitive); err != nil {
+		return
+	}
+	if SplitAdvice, err = c.Bool("commitgpt.splitAdvice", "COMMITGPT_SPLIT_ADVICE", SplitAdvice); err != nil {
+		return
+	}
+	MessageTemplate = c.String("commitgpt.messageTemplate", "COMMITGPT_MESSAGE_TEMPLATE", MessageTemplate)
+	CheckThreshold = strings.ToLower(c.String("commitgpt.checkThreshold", "COMMITGPT_CHECK_THRESHOLD", CheckThreshold))
+	if _, err = parseSeverity(CheckThreshold); err != nil {
+		return fmt.Errorf("commitgpt.checkThreshold: %w", err)
+	}
+	if BatchThreshold, err = c.Int("commitgpt.batchThreshold", "COMMITGPT_BATCH_THRESHOLD", BatchThreshold); err != nil {
+		return
+	}
+	if MaxTokens, err = c.Int("commitgpt.maxTokens", "COMMITGPT_MAX_TOKENS", MaxTokens); err != nil {
+		return
+	}
+	if Timeout, err = c.Duration("commitgpt.timeout", "COMMITGPT_TIMEOUT", Timeout); err != nil {
+		return
+	}
+	if ThinkingBudget, err = c.Int("commitgpt.thinkingBudget", "COMMITGPT_THINKING_BUDGET", ThinkingBudget); err != nil {
+		return
+	}
+	if ThinkingBudget > 0 && ThinkingBudget < 1024 {
+		return fmt.Errorf("commitgpt.thinkingBudget: must be at least 1024 tokens, got %d", ThinkingBudget)
+	}
+	return
+}
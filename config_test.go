@@ -0,0 +1,58 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func Test_parseGitConfig(t *testing.T) {
+	data := "file:.git/config\x00commitgpt.thinkingbudget\n2000\x00" +
+		"file:/home/me/.gitconfig\x00commitgpt.model\nclaude-a\x00" +
+		"file:.git/config\x00commitgpt.model\nclaude b\x00" +
+		"file:.git/config\x00commitgpt.flag\x00"
+	want := gitConfig{
+		"commitgpt.thinkingbudget": {{Value: "2000", Origin: "file:.git/config"}},
+		"commitgpt.model": {
+			{Value: "claude-a", Origin: "file:/home/me/.gitconfig"},
+			{Value: "claude b", Origin: "file:.git/config"},
+		},
+		"commitgpt.flag": {{Value: "", Origin: "file:.git/config"}},
+	}
+	if diff := cmp.Diff(want, parseGitConfig([]byte(data))); diff != "" {
+		t.Errorf("parseGitConfig() mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func Test_gitConfig_lookup(t *testing.T) {
+	config := gitConfig{
+		"commitgpt.model": {{Value: "claude-a"}, {Value: "claude-b"}},
+	}
+	if got := config.String("commitgpt.Model", "COMMITGPT_MODEL", "default"); got != "claude-b" {
+		t.Errorf("String() = %q, want %q", got, "claude-b")
+	}
+	if got := config.String("commitgpt.other", "", "default"); got != "default" {
+		t.Errorf("String() = %q, want %q", got, "default")
+	}
+	t.Setenv("COMMITGPT_MODEL", "claude-env")
+	if got := config.String("commitgpt.model", "COMMITGPT_MODEL", "default"); got != "claude-env" {
+		t.Errorf("String() = %q, want %q", got, "claude-env")
+	}
+	if _, err := (gitConfig{"commitgpt.maxtokens": {{Value: "lots"}}}).Int("commitgpt.maxTokens", "", 1); err == nil {
+		t.Error("Int() expected error")
+	}
+}
+
+func Test_applyConfig_emptyModel(t *testing.T) {
+	defer func(models []modelSpec, providers map[string]provider) {
+		Models, Providers = models, providers
+	}(Models, Providers)
+	resetFailurePolicies(t)
+	if err := applyConfig(gitConfig{"commitgpt.model": {{Value: " "}}}); err == nil {
+		t.Error("applyConfig() with a blank commitgpt.model expected error")
+	}
+	t.Setenv("COMMITGPT_MODEL", "")
+	if err := applyConfig(gitConfig{}); err == nil {
+		t.Error("applyConfig() with an empty COMMITGPT_MODEL expected error")
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"regexp"
+	"strings"
+)
+
+// conventionalCommit is a commit message in the Conventional Commits format:
+// type(scope)!: description, a body, and footers.
+type conventionalCommit struct {
+	Hash        string
+	Type        string
+	Scope       string
+	Description string
+	Body        string
+	// Breaking is set by a ! before the colon or a BREAKING CHANGE footer,
+	// whose text is in BreakingNote.
+	Breaking     bool
+	BreakingNote string
+}
+
+var conventionalPattern = regexp.MustCompile(`^([A-Za-z]+)(?:\(([^)]*)\))?(!)?: +(\S.*)$`)
+
+var breakingFooterPattern = regexp.MustCompile(`(?m)^BREAKING[ -]CHANGE: *`)
+
+// parseConventional parses message. It reports false when the subject line
+// is not a conventional commit.
+func parseConventional(message string) (conventionalCommit, bool) {
+	message = strings.TrimSpace(message)
+	subject, body, _ := strings.Cut(message, "\n")
+	m := conventionalPattern.FindStringSubmatch(strings.TrimSpace(subject))
+	if m == nil {
+		return conventionalCommit{Description: strings.TrimSpace(subject), Body: strings.TrimSpace(body)}, false
+	}
+	c := conventionalCommit{
+		Type:        strings.ToLower(m[1]),
+		Scope:       strings.TrimSpace(m[2]),
+		Breaking:    m[3] == "!",
+		Description: strings.TrimSpace(m[4]),
+		Body:        strings.TrimSpace(body),
+	}
+	if loc := breakingFooterPattern.FindStringIndex(c.Body); loc != nil {
+		c.Breaking = true
+		// The note runs until the next footer or the end of the message.
+		note := c.Body[loc[1]:]
+		if i := footerPattern.FindStringIndex(note); i != nil {
+			note = note[:i[0]]
+		}
+		c.BreakingNote = strings.TrimSpace(note)
+	}
+	return c, true
+}
+
+// footerPattern matches the start of a git trailer style footer, such as
+// "Refs: #1" or "Reviewed-by: A".
+var footerPattern = regexp.MustCompile(`(?m)^(?:[A-Za-z-]+|BREAKING CHANGE): |^[A-Za-z-]+ #`)
+
+// rangeCommits returns the commits in the revision range rev, such as
+// v1.0.0..HEAD, oldest first. Merges are skipped.
+func rangeCommits(ctx context.Context, rev string) ([]conventionalCommit, error) {
+	out, err := git(ctx, "log", "-z", "--reverse", "--no-merges", "--format=%H%x1f%B", rev, "--")
+	if err != nil {
+		return nil, err
+	}
+	var commits []conventionalCommit
+	for _, entry := range splitNUL(out) {
+		hash, message, _ := strings.Cut(entry, "\x1f")
+		c, _ := parseConventional(message)
+		c.Hash = strings.TrimSpace(hash)
+		commits = append(commits, c)
+	}
+	return commits, nil
+}
+
+// lastTag returns the most recent tag reachable from rev, or "" when there is
+// none.
+func lastTag(ctx context.Context, rev string) string {
+	out, err := git(ctx, "describe", "--tags", "--abbrev=0", rev)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(out))
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"os/exec"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func Test_parseConventional(t *testing.T) {
+	tests := []struct {
+		name    string
+		message string
+		want    conventionalCommit
+		wantOK  bool
+	}{
+		{
+			name:    "type and description",
+			message: "fix: handle empty input\n",
+			want:    conventionalCommit{Type: "fix", Description: "handle empty input"},
+			wantOK:  true,
+		},
+		{
+			name:    "scope and body",
+			message: "Feat(api): add users\n\nThe users endpoint.\n",
+			want:    conventionalCommit{Type: "feat", Scope: "api", Description: "add users", Body: "The users endpoint."},
+			wantOK:  true,
+		},
+		{
+			name:    "breaking marker",
+			message: "refactor(api,web)!: drop v1",
+			want:    conventionalCommit{Type: "refactor", Scope: "api,web", Description: "drop v1", Breaking: true},
+			wantOK:  true,
+		},
+		{
+			name:    "breaking footer",
+			message: "feat: new config\n\nBody.\n\nBREAKING CHANGE: the config file moved\nto ~/.config.\nRefs: #12\n",
+			want: conventionalCommit{Type: "feat", Description: "new config", Breaking: true,
+				Body:         "Body.\n\nBREAKING CHANGE: the config file moved\nto ~/.config.\nRefs: #12",
+				BreakingNote: "the config file moved\nto ~/.config."},
+			wantOK: true,
+		},
+		{
+			name:    "breaking footer with a hyphen",
+			message: "fix: x\n\nBREAKING-CHANGE: y",
+			want:    conventionalCommit{Type: "fix", Description: "x", Body: "BREAKING-CHANGE: y", Breaking: true, BreakingNote: "y"},
+			wantOK:  true,
+		},
+		{
+			name:    "not conventional",
+			message: "Add users\n\nBody.",
+			want:    conventionalCommit{Description: "Add users", Body: "Body."},
+		},
+		{
+			name:    "no description",
+			message: "fix: ",
+			want:    conventionalCommit{Description: "fix:"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := parseConventional(tt.message)
+			if ok != tt.wantOK {
+				t.Errorf("parseConventional() ok = %v, want %v", ok, tt.wantOK)
+			}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("parseConventional() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func Test_rangeCommits(t *testing.T) {
+	ctx := context.Background()
+	chdirTempRepo(t)
+	stageFiles(t, map[string]string{"a": "a"})
+	commitStaged(t, "feat: a")
+	exec.Command("git", "tag", "v1.0.0").Run()
+	stageFiles(t, map[string]string{"b": "b"})
+	commitStaged(t, "fix(b): b")
+	stageFiles(t, map[string]string{"c": "c"})
+	commitStaged(t, "Add c")
+
+	if got := lastTag(ctx, "HEAD"); got != "v1.0.0" {
+		t.Errorf("lastTag() = %q, want v1.0.0", got)
+	}
+	if got := lastTag(ctx, "HEAD~2^"); got != "" {
+		t.Errorf("lastTag() = %q, want none", got)
+	}
+	commits, err := rangeCommits(ctx, "v1.0.0..HEAD")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, c := range commits {
+		if len(c.Hash) != 40 {
+			t.Errorf("rangeCommits() hash = %q", c.Hash)
+		}
+		got = append(got, c.Type+"|"+c.Scope+"|"+c.Description)
+	}
+	if diff := cmp.Diff([]string{"fix|b|b", "||Add c"}, got); diff != "" {
+		t.Errorf("rangeCommits() mismatch (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// credentials are the places an API key may be found, tried in the order of
+// the fields below.
+type credentials struct {
+	// Env is the environment variable holding the key.
+	Env string
+	// File holds the key and must not be readable by group or others.
+	File string
+	// Helper is a shell command that prints the key.
+	Helper string
+	// HelperTTL is how long the helper's output is cached. Zero disables
+	// the cache.
+	HelperTTL time.Duration
+	// URL is looked up with git credential fill; the password is the key.
+	URL string
+}
+
+var (
+	Credentials = credentials{Env: "ANTHROPIC_API_KEY", HelperTTL: 5 * time.Minute}
+
+	// Verbose explains on stderr where the API key came from.
+	Verbose bool
+)
+
+// credentials returns the credential settings of the model's provider. A
+// provider other than the default only uses its own, so that the Anthropic
+// key is never sent to its endpoint.
+func (m modelSpec) credentials() credentials {
+	if m.Provider == "" {
+		return Credentials
+	}
+	p := Providers[m.Provider]
+	return credentials{
+		Env:       p.APIKeyEnv,
+		File:      p.APIKeyFile,
+		Helper:    p.APIKeyHelper,
+		HelperTTL: Credentials.HelperTTL,
+		URL:       p.CredentialURL,
+	}
+}
+
+// resolve returns the first API key found and a description of where it was
+// found. If there is none, the error wraps errNoAPIKey and says why each
+// source was passed over.
+func (c credentials) resolve(ctx context.Context) (key, source string, err error) {
+	var reasons []string
+	try := func(source string, lookup func() (string, error)) bool {
+		v, err := lookup()
+		if err != nil {
+			reasons = append(reasons, fmt.Sprintf("%s: %v", source, err))
+			return false
+		}
+		key = strings.TrimSpace(v)
+		if key == "" {
+			reasons = append(reasons, source+": empty")
+			return false
+		}
+		return true
+	}
+
+	if c.Env != "" {
+		source = "environment variable " + c.Env
+		if try(source, func() (string, error) {
+			v, ok := os.LookupEnv(c.Env)
+			if !ok {
+				return "", fmt.Errorf("not set")
+			}
+			return v, nil
+		}) {
+			return
+		}
+	}
+	if c.File != "" {
+		source = "key file " + c.File
+		if try(source, func() (string, error) { return readKeyFile(c.File) }) {
+			return
+		}
+	}
+	if c.Helper != "" {
+		source = "helper " + c.Helper
+		if try(source, func() (string, error) { return runKeyHelper(ctx, c.Helper, c.HelperTTL) }) {
+			return
+		}
+	}
+	if c.URL != "" {
+		source = "git credential for " + c.URL
+		if try(source, func() (string, error) { return gitCredential(ctx, c.URL) }) {
+			return
+		}
+	}
+	if len(reasons) == 0 {
+		reasons = append(reasons, "no credential sources configured")
+	}
+	return "", "", fmt.Errorf("%w (%s)", errNoAPIKey, strings.Join(reasons, "; "))
+}
+
+func expandHome(path string) string {
+	if rest, ok := strings.CutPrefix(path, "~/"); ok {
+		if home, err := os.UserHomeDir(); err == nil {
+			return filepath.Join(home, rest)
+		}
+	}
+	return path
+}
+
+// readKeyFile reads an API key from path, refusing to use it if anyone other
+// than its owner may read it.
+func readKeyFile(path string) (string, error) {
+	path = expandHome(path)
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	if perm := info.Mode().Perm(); perm&0o077 != 0 {
+		return "", fmt.Errorf("permissions %#o are too open, run chmod 600 %s", perm, path)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// runKeyHelper runs helper with sh and returns what it prints. The output is
+// cached in the user's cache directory for ttl, and removed once it expires.
+func runKeyHelper(ctx context.Context, helper string, ttl time.Duration) (string, error) {
+	var cacheFile string
+	if ttl > 0 {
+		if dir, err := os.UserCacheDir(); err == nil {
+			sum := sha256.Sum256([]byte(helper))
+			cacheFile = filepath.Join(dir, "commitgpt", "apikey-"+hex.EncodeToString(sum[:8]))
+		}
+	}
+	if cacheFile != "" {
+		if info, err := os.Stat(cacheFile); err == nil && time.Since(info.ModTime()) < ttl {
+			if key, err := readKeyFile(cacheFile); err == nil && strings.TrimSpace(key) != "" {
+				return key, nil
+			}
+		} else if err == nil {
+			os.Remove(cacheFile)
+		}
+	}
+
+	cmd := exec.CommandContext(ctx, "sh", "-c", helper)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", fmt.Errorf("%w: %s", err, msg)
+		}
+		return "", err
+	}
+
+	if cacheFile != "" && len(bytes.TrimSpace(out)) > 0 {
+		if err := os.MkdirAll(filepath.Dir(cacheFile), 0o700); err == nil {
+			os.WriteFile(cacheFile, out, 0o600)
+		}
+	}
+	return string(out), nil
+}
+
+// gitCredential asks git's credential helpers for the password of rawURL,
+// without prompting.
+func gitCredential(ctx context.Context, rawURL string) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", err
+	}
+	var input strings.Builder
+	fmt.Fprintf(&input, "protocol=%s\nhost=%s\n", u.Scheme, u.Host)
+	if path := strings.TrimPrefix(u.Path, "/"); path != "" {
+		fmt.Fprintf(&input, "path=%s\n", path)
+	}
+	if u.User != nil {
+		fmt.Fprintf(&input, "username=%s\n", u.User.Username())
+	}
+	input.WriteString("\n")
+
+	cmd := exec.CommandContext(ctx, "git", "credential", "fill")
+	cmd.Stdin = strings.NewReader(input.String())
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0", "GIT_ASKPASS=", "SSH_ASKPASS=")
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("git credential fill: %w", err)
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		if password, ok := strings.CutPrefix(scanner.Text(), "password="); ok {
+			return password, nil
+		}
+	}
+	return "", fmt.Errorf("git credential fill: no password")
+}
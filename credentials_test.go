@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_credentials_resolve(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("XDG_CACHE_HOME", filepath.Join(dir, "cache"))
+	t.Setenv("HOME", dir)
+	t.Setenv("GIT_CONFIG_NOSYSTEM", "1")
+	gitconfig := filepath.Join(dir, "gitconfig")
+	t.Setenv("GIT_CONFIG_GLOBAL", gitconfig)
+	credentialConfig := `[credential "https://api.example.com"]
+	helper = "!f() { test \"$1\" = get && printf \"username=commitgpt\\npassword=git-key\\n\"; }; f"
+`
+	if err := os.WriteFile(gitconfig, []byte(credentialConfig), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	keyFile := filepath.Join(dir, "key")
+	if err := os.WriteFile(keyFile, []byte("file-key\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	openKeyFile := filepath.Join(dir, "open-key")
+	if err := os.WriteFile(openKeyFile, []byte("open-key\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(openKeyFile, 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("SET_KEY", "env-key")
+	t.Setenv("EMPTY_KEY", "")
+
+	tests := []struct {
+		name       string
+		creds      credentials
+		wantKey    string
+		wantSource string
+		wantErr    []string
+	}{
+		{
+			name:       "environment",
+			creds:      credentials{Env: "SET_KEY", File: keyFile},
+			wantKey:    "env-key",
+			wantSource: "environment variable SET_KEY",
+		},
+		{
+			name:       "key file",
+			creds:      credentials{Env: "EMPTY_KEY", File: "~/key"},
+			wantKey:    "file-key",
+			wantSource: "key file ~/key",
+		},
+		{
+			name:       "helper",
+			creds:      credentials{Env: "UNSET_KEY", File: openKeyFile, Helper: "echo helper-key"},
+			wantKey:    "helper-key",
+			wantSource: "helper echo helper-key",
+		},
+		{
+			name:       "git credential",
+			creds:      credentials{Helper: "exit 3", URL: "https://api.example.com"},
+			wantKey:    "git-key",
+			wantSource: "git credential for https://api.example.com",
+		},
+		{
+			name:  "none",
+			creds: credentials{Env: "UNSET_KEY", File: openKeyFile, Helper: "echo oops >&2; exit 3", URL: "https://other.example.com"},
+			wantErr: []string{
+				"environment variable UNSET_KEY: not set",
+				"permissions 0644 are too open",
+				"helper echo oops >&2; exit 3: exit status 3: oops",
+				"git credential for https://other.example.com: git credential fill",
+			},
+		},
+		{
+			name:    "unconfigured",
+			wantErr: []string{"no credential sources configured"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, source, err := tt.creds.resolve(context.Background())
+			if key != tt.wantKey {
+				t.Errorf("resolve() key = %q, want %q", key, tt.wantKey)
+			}
+			if source != tt.wantSource {
+				t.Errorf("resolve() source = %q, want %q", source, tt.wantSource)
+			}
+			if len(tt.wantErr) == 0 {
+				if err != nil {
+					t.Errorf("resolve() err = %v", err)
+				}
+				return
+			}
+			if !errors.Is(err, errNoAPIKey) {
+				t.Errorf("resolve() err = %v, want %v", err, errNoAPIKey)
+			}
+			for _, want := range tt.wantErr {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("resolve() err = %v, want to contain %q", err, want)
+				}
+			}
+		})
+	}
+}
+
+func Test_runKeyHelper_cache(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("XDG_CACHE_HOME", dir)
+	counter := filepath.Join(dir, "count")
+	helper := "echo run >> " + counter + "; echo cached-key"
+
+	for i := 0; i < 2; i++ {
+		key, err := runKeyHelper(context.Background(), helper, time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.TrimSpace(key) != "cached-key" {
+			t.Errorf("runKeyHelper() = %q, want %q", key, "cached-key")
+		}
+	}
+	if runs, _ := os.ReadFile(counter); strings.Count(string(runs), "run") != 1 {
+		t.Errorf("helper ran %d times, want 1", strings.Count(string(runs), "run"))
+	}
+
+	if _, err := runKeyHelper(context.Background(), helper, 0); err != nil {
+		t.Fatal(err)
+	}
+	if runs, _ := os.ReadFile(counter); strings.Count(string(runs), "run") != 2 {
+		t.Errorf("helper ran %d times, want 2", strings.Count(string(runs), "run"))
+	}
+}
+
+func Test_runKeyHelper_expiredCache(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("XDG_CACHE_HOME", dir)
+	ok := filepath.Join(dir, "ok")
+	helper := "test -f " + ok + " && echo cached-key"
+
+	if err := os.WriteFile(ok, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := runKeyHelper(context.Background(), helper, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	files, _ := filepath.Glob(filepath.Join(dir, "commitgpt", "apikey-*"))
+	if len(files) != 1 {
+		t.Fatalf("cache files = %v, want 1", files)
+	}
+	old := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(files[0], old, old); err != nil {
+		t.Fatal(err)
+	}
+
+	os.Remove(ok)
+	if _, err := runKeyHelper(context.Background(), helper, time.Minute); err == nil {
+		t.Fatal("runKeyHelper() err = nil, want the helper's error")
+	}
+	if _, err := os.Stat(files[0]); !os.IsNotExist(err) {
+		t.Errorf("expired cache file still exists: %v", err)
+	}
+}
+
+func Test_modelSpec_credentials(t *testing.T) {
+	defer func(creds credentials, providers map[string]provider) {
+		Credentials, Providers = creds, providers
+	}(Credentials, Providers)
+	Credentials = credentials{Env: "ANTHROPIC_API_KEY", Helper: "global-helper", HelperTTL: time.Minute}
+	Providers = map[string]provider{"gateway": {APIKeyEnv: "GATEWAY_KEY", CredentialURL: "https://gateway.example.com"}}
+
+	got := modelSpec{Provider: "gateway", Name: "claude"}.credentials()
+	want := credentials{Env: "GATEWAY_KEY", HelperTTL: time.Minute, URL: "https://gateway.example.com"}
+	if got != want {
+		t.Errorf("credentials() = %+v, want %+v", got, want)
+	}
+	if got := (modelSpec{Name: "claude"}).credentials(); got != Credentials {
+		t.Errorf("credentials() = %+v, want %+v", got, Credentials)
+	}
+}
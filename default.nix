@@ -14,6 +14,7 @@ pkgs.buildGoModule rec {
     ++ buildInputs;
 
   buildInputs = with pkgs; [
+    bash
     pandoc
     git
   ];
@@ -22,7 +23,7 @@ pkgs.buildGoModule rec {
     wrapProgram $out/bin/commitgpt \
       --set PATH ${pkgs.lib.makeBinPath buildInputs} \
       --run 'export ANTHROPIC_LOG_DIR="$HOME/.config/anthropic/logs"' \
-      --run 'export ANTHROPIC_API_KEY="$(${pkgs.libsecret}/bin/secret-tool lookup anthropic-api-key commitgpt)"'
+      --set-default COMMITGPT_API_KEY_HELPER '${pkgs.libsecret}/bin/secret-tool lookup anthropic-api-key commitgpt'
   '';
 
   meta = with pkgs.lib; {
@@ -0,0 +1,300 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// depParsers read the dependencies, and their versions, from the manifests
+// and lockfiles that are summarised rather than sent as a diff.
+var depParsers = map[string]func([]byte) (depVersions, error){
+	"go.mod":            parseGoMod,
+	"go.sum":            parseGoSum,
+	"package-lock.json": parsePackageLock,
+	"Cargo.lock":        parseCargoLock,
+}
+
+// isDepFile reports whether p is a manifest or lockfile that commitgpt knows
+// how to summarise.
+func isDepFile(p string) bool {
+	_, ok := depParsers[path.Base(p)]
+	return ok
+}
+
+// depVersions maps each dependency to the versions of it that are used.
+type depVersions map[string][]string
+
+func (d depVersions) add(name, version string) {
+	for _, v := range d[name] {
+		if v == version {
+			return
+		}
+	}
+	d[name] = append(d[name], version)
+}
+
+// depChange is a dependency that was added (From is empty), removed (To is
+// empty) or changed version.
+type depChange struct {
+	Name, From, To string
+}
+
+func (c depChange) String() string {
+	switch {
+	case c.From == "":
+		return fmt.Sprintf("added %s %s", c.Name, c.To)
+	case c.To == "":
+		return fmt.Sprintf("removed %s %s", c.Name, c.From)
+	case compareVersions(c.From, c.To) > 0:
+		return fmt.Sprintf("downgraded %s from %s to %s", c.Name, c.From, c.To)
+	}
+	return fmt.Sprintf("upgraded %s from %s to %s", c.Name, c.From, c.To)
+}
+
+// diffDeps lists the changes from before to after, sorted by name. When the same
+// number of versions of a dependency were removed and added, they are paired
+// up as version changes.
+func diffDeps(before, after depVersions) []depChange {
+	names := map[string]bool{}
+	for name := range before {
+		names[name] = true
+	}
+	for name := range after {
+		names[name] = true
+	}
+	sorted := make([]string, 0, len(names))
+	for name := range names {
+		sorted = append(sorted, name)
+	}
+	sort.Strings(sorted)
+
+	var changes []depChange
+	for _, name := range sorted {
+		removed, added := versionsNotIn(before[name], after[name]), versionsNotIn(after[name], before[name])
+		if len(removed) == len(added) {
+			for i := range removed {
+				changes = append(changes, depChange{Name: name, From: removed[i], To: added[i]})
+			}
+			continue
+		}
+		for _, v := range removed {
+			changes = append(changes, depChange{Name: name, From: v})
+		}
+		for _, v := range added {
+			changes = append(changes, depChange{Name: name, To: v})
+		}
+	}
+	return changes
+}
+
+// versionsNotIn returns the versions in a that are not in b, oldest first.
+func versionsNotIn(a, b []string) (versions []string) {
+	for _, v := range a {
+		found := false
+		for _, w := range b {
+			found = found || v == w
+		}
+		if !found {
+			versions = append(versions, v)
+		}
+	}
+	sort.Slice(versions, func(i, j int) bool { return compareVersions(versions[i], versions[j]) < 0 })
+	return
+}
+
+// compareVersions compares versions such as v1.2.10 and 1.2.9 part by part,
+// numerically where both parts are numbers.
+func compareVersions(a, b string) int {
+	split := func(s string) []string {
+		return strings.FieldsFunc(strings.TrimPrefix(s, "v"), func(r rune) bool {
+			return r == '.' || r == '-' || r == '+'
+		})
+	}
+	as, bs := split(a), split(b)
+	for i := 0; i < len(as) && i < len(bs); i++ {
+		an, aErr := strconv.Atoi(as[i])
+		bn, bErr := strconv.Atoi(bs[i])
+		switch {
+		case aErr == nil && bErr == nil && an != bn:
+			if an < bn {
+				return -1
+			}
+			return 1
+		case (aErr != nil || bErr != nil) && as[i] != bs[i]:
+			return strings.Compare(as[i], bs[i])
+		}
+	}
+	return len(as) - len(bs)
+}
+
+// parseGoMod reads the go and toolchain directives and the requirements of
+// a go.mod file.
+func parseGoMod(data []byte) (depVersions, error) {
+	deps := depVersions{}
+	inRequire := false
+	scanner := bufio.NewScanner(strings.NewReader(string(data)))
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(scanner.Text(), "//")
+		fields := strings.Fields(line)
+		switch {
+		case len(fields) == 0:
+		case inRequire && fields[0] == ")":
+			inRequire = false
+		case inRequire && len(fields) == 2:
+			deps.add(fields[0], fields[1])
+		case fields[0] == "require" && len(fields) == 2 && fields[1] == "(":
+			inRequire = true
+		case fields[0] == "require" && len(fields) == 3:
+			deps.add(fields[1], fields[2])
+		case (fields[0] == "go" || fields[0] == "toolchain") && len(fields) == 2:
+			deps.add(fields[0], fields[1])
+		}
+	}
+	return deps, scanner.Err()
+}
+
+// parseGoSum reads the module versions of a go.sum file.
+func parseGoSum(data []byte) (depVersions, error) {
+	deps := depVersions{}
+	for i, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("go.sum: line %d: malformed", i+1)
+		}
+		deps.add(fields[0], strings.TrimSuffix(fields[1], "/go.mod"))
+	}
+	return deps, nil
+}
+
+// packageLockDep is a dependency in a version 1 package-lock.json.
+type packageLockDep struct {
+	Version      string                    `json:"version"`
+	Dependencies map[string]packageLockDep `json:"dependencies"`
+}
+
+// parsePackageLock reads the installed packages of a package-lock.json, from
+// "packages" in lockfile version 2 and later, or from the nested
+// "dependencies" of version 1.
+func parsePackageLock(data []byte) (depVersions, error) {
+	var lock struct {
+		Packages map[string]struct {
+			Version string `json:"version"`
+		} `json:"packages"`
+		Dependencies map[string]packageLockDep `json:"dependencies"`
+	}
+	if err := json.Unmarshal(data, &lock); err != nil {
+		return nil, fmt.Errorf("package-lock.json: %w", err)
+	}
+	deps := depVersions{}
+	if lock.Packages != nil {
+		for key, pkg := range lock.Packages {
+			i := strings.LastIndex(key, "node_modules/")
+			if i < 0 || pkg.Version == "" {
+				// The root project, or a workspace link.
+				continue
+			}
+			deps.add(key[i+len("node_modules/"):], pkg.Version)
+		}
+		return deps, nil
+	}
+	var walk func(map[string]packageLockDep)
+	walk = func(dependencies map[string]packageLockDep) {
+		for name, dep := range dependencies {
+			deps.add(name, dep.Version)
+			walk(dep.Dependencies)
+		}
+	}
+	walk(lock.Dependencies)
+	return deps, nil
+}
+
+// parseCargoLock reads the packages of a Cargo.lock.
+func parseCargoLock(data []byte) (depVersions, error) {
+	deps := depVersions{}
+	var name, version string
+	flush := func() {
+		if name != "" && version != "" {
+			deps.add(name, version)
+		}
+		name, version = "", ""
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "[") {
+			flush()
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			continue
+		}
+		switch strings.TrimSpace(key) {
+		case "name":
+			name, _ = strconv.Unquote(strings.TrimSpace(value))
+		case "version":
+			version, _ = strconv.Unquote(strings.TrimSpace(value))
+		}
+	}
+	flush()
+	return deps, nil
+}
+
+// parseDeps parses data, which is empty when the file does not exist.
+func parseDeps(parse func([]byte) (depVersions, error), data []byte) (depVersions, error) {
+	if len(bytes.TrimSpace(data)) == 0 {
+		return depVersions{}, nil
+	}
+	return parse(data)
+}
+
+// summariseDeps describes the dependency changes in r to paths. Files that
+// cannot be parsed fall back to the line counts of summariseDiff. When
+// onlyDeps is set, a deps scope is suggested.
+func summariseDeps(ctx context.Context, r revRange, paths []string, onlyDeps bool) (string, error) {
+	var summary strings.Builder
+	var fallback []string
+	for _, p := range paths {
+		parse := depParsers[path.Base(p)]
+		oldData, newData := r.blobs(ctx, p)
+		before, err := parseDeps(parse, oldData)
+		if err != nil {
+			fallback = append(fallback, p)
+			continue
+		}
+		after, err := parseDeps(parse, newData)
+		if err != nil {
+			fallback = append(fallback, p)
+			continue
+		}
+		changes := diffDeps(before, after)
+		if len(changes) == 0 {
+			fmt.Fprintf(&summary, "Dependency changes in %s: none, only checksums or formatting changed\n", p)
+			continue
+		}
+		fmt.Fprintf(&summary, "Dependency changes in %s (diff not shown):\n", p)
+		for _, change := range changes {
+			fmt.Fprintf(&summary, "- %s\n", change)
+		}
+	}
+	if len(fallback) > 0 {
+		unparsed, err := summariseDiff(ctx, r, fallback)
+		if err != nil {
+			return "", err
+		}
+		summary.WriteString(unparsed)
+	}
+	if onlyDeps {
+		summary.WriteString("Only dependencies changed: use the deps scope, as in chore(deps): or fix(deps):.\n")
+	}
+	return summary.String(), nil
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"os/exec"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func Test_depParsers(t *testing.T) {
+	tests := []struct {
+		name  string
+		parse func([]byte) (depVersions, error)
+		data  string
+		want  depVersions
+	}{
+		{
+			name:  "go.mod",
+			parse: parseGoMod,
+			data: `module example.com/app // the app
+
+go 1.21
+
+require example.com/single v1.0.0
+
+require (
+	github.com/google/go-cmp v0.6.0
+	golang.org/x/mod v0.17.0 // indirect
+)
+
+replace example.com/single => ../single
+`,
+			want: depVersions{
+				"go":                       {"1.21"},
+				"example.com/single":       {"v1.0.0"},
+				"github.com/google/go-cmp": {"v0.6.0"},
+				"golang.org/x/mod":         {"v0.17.0"},
+			},
+		},
+		{
+			name:  "go.sum",
+			parse: parseGoSum,
+			data: `github.com/google/go-cmp v0.5.9 h1:abc=
+github.com/google/go-cmp v0.6.0 h1:def=
+github.com/google/go-cmp v0.6.0/go.mod h1:ghi=
+`,
+			want: depVersions{"github.com/google/go-cmp": {"v0.5.9", "v0.6.0"}},
+		},
+		{
+			name:  "package-lock.json v3",
+			parse: parsePackageLock,
+			data: `{"lockfileVersion": 3, "packages": {
+				"": {"name": "app", "version": "1.0.0"},
+				"node_modules/@types/node": {"version": "20.1.0"},
+				"node_modules/a/node_modules/left-pad": {"version": "1.3.0"},
+				"packages/lib": {"version": "0.1.0"},
+				"node_modules/lib": {"resolved": "packages/lib", "link": true}
+			}}`,
+			want: depVersions{"@types/node": {"20.1.0"}, "left-pad": {"1.3.0"}},
+		},
+		{
+			name:  "package-lock.json v1",
+			parse: parsePackageLock,
+			data: `{"lockfileVersion": 1, "dependencies": {
+				"a": {"version": "1.0.0", "dependencies": {"left-pad": {"version": "1.3.0"}}},
+				"left-pad": {"version": "1.1.0"}
+			}}`,
+			want: depVersions{"a": {"1.0.0"}, "left-pad": {"1.1.0", "1.3.0"}},
+		},
+		{
+			name:  "Cargo.lock",
+			parse: parseCargoLock,
+			data: `version = 3
+
+[[package]]
+name = "serde"
+version = "1.0.200"
+source = "registry+https://github.com/rust-lang/crates.io-index"
+dependencies = [
+ "serde_derive",
+]
+
+[[package]]
+name = "app"
+version = "0.1.0"
+`,
+			want: depVersions{"serde": {"1.0.200"}, "app": {"0.1.0"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.parse([]byte(tt.data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, versions := range got {
+				sort.Slice(versions, func(i, j int) bool { return compareVersions(versions[i], versions[j]) < 0 })
+			}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("parse() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func Test_diffDeps(t *testing.T) {
+	before := depVersions{
+		"go":       {"1.21"},
+		"kept":     {"v1.0.0"},
+		"upgraded": {"v1.2.9"},
+		"older":    {"2.0.0"},
+		"removed":  {"v0.1.0"},
+		"multi":    {"1.0.0", "2.0.0"},
+	}
+	after := depVersions{
+		"go":       {"1.22"},
+		"kept":     {"v1.0.0"},
+		"upgraded": {"v1.2.10"},
+		"older":    {"1.9.0"},
+		"added":    {"v3.0.0"},
+		"multi":    {"2.0.0", "3.0.0", "3.1.0"},
+	}
+	var got []string
+	for _, change := range diffDeps(before, after) {
+		got = append(got, change.String())
+	}
+	want := []string{
+		"added added v3.0.0",
+		"upgraded go from 1.21 to 1.22",
+		"removed multi 1.0.0",
+		"added multi 3.0.0",
+		"added multi 3.1.0",
+		"downgraded older from 2.0.0 to 1.9.0",
+		"removed removed v0.1.0",
+		"upgraded upgraded from v1.2.9 to v1.2.10",
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("diffDeps() mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func Test_compareVersions(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"v1.2.10", "v1.2.9", 1},
+		{"1.0.0", "v1.0.0", 0},
+		{"1.0", "1.0.1", -1},
+		{"1.0.0-beta", "1.0.0-alpha", 1},
+	}
+	for _, tt := range tests {
+		got := compareVersions(tt.a, tt.b)
+		if got > 0 {
+			got = 1
+		} else if got < 0 {
+			got = -1
+		}
+		if got != tt.want {
+			t.Errorf("compareVersions(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func Test_stagedDiff_deps(t *testing.T) {
+	chdirTempRepo(t)
+	stageFiles(t, map[string]string{
+		"go.mod": "module example.com/app\n\ngo 1.21\n\nrequire github.com/google/go-cmp v0.5.9\n",
+	})
+	commitStaged(t, "init")
+	stageFiles(t, map[string]string{
+		"go.mod":           "module example.com/app\n\ngo 1.21\n\nrequire (\n\tgithub.com/google/go-cmp v0.6.0\n\tgolang.org/x/mod v0.17.0\n)\n",
+		"web/package.json": "{}\n",
+	})
+
+	got, err := stagedDiff(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "Dependency changes in go.mod (diff not shown):\n" +
+		"- upgraded github.com/google/go-cmp from v0.5.9 to v0.6.0\n" +
+		"- added golang.org/x/mod v0.17.0\n"
+	if !strings.Contains(got, want) || strings.Contains(got, "+require") {
+		t.Errorf("stagedDiff() = %s, want the go.mod summary", got)
+	}
+	if strings.Contains(got, "deps scope") {
+		t.Errorf("stagedDiff() suggests the deps scope with other changes staged")
+	}
+
+	exec.Command("git", "rm", "-q", "--cached", "web/package.json").Run()
+	got, err = stagedDiff(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(got, "use the deps scope") {
+		t.Errorf("stagedDiff() = %s, want the deps scope suggested", got)
+	}
+}
@@ -0,0 +1,295 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type checkStatus int
+
+const (
+	checkPass checkStatus = iota
+	checkWarn
+	checkFail
+)
+
+func (s checkStatus) String() string {
+	switch s {
+	case checkPass:
+		return "PASS"
+	case checkWarn:
+		return "WARN"
+	}
+	return "FAIL"
+}
+
+// checkResult is one line of the doctor's report. Hint says how to fix a
+// warning or failure.
+type checkResult struct {
+	Name   string
+	Status checkStatus
+	Detail string
+	Hint   string
+}
+
+// MinGitVersion is the oldest git that supports everything commitgpt runs.
+var MinGitVersion = [2]int{2, 9}
+
+func doctorCommand(ctx context.Context, _ gitConfig, args []string) int {
+	if runDoctor(ctx, os.Stdout) {
+		return 0
+	}
+	return 1
+}
+
+// runDoctor checks that the hook is able to work and writes a report to w. It
+// returns false if any check failed. It loads the configuration itself, so
+// that a bad setting is reported as a failed check.
+func runDoctor(ctx context.Context, w io.Writer) bool {
+	var results []checkResult
+	results = append(results, checkGit(ctx), checkRepo(ctx), checkHook(ctx), checkPandoc(ctx))
+	config, err := loadConfig(ctx)
+	results = append(results, checkConfig(config, err))
+	if Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, Timeout)
+		defer cancel()
+	}
+	results = append(results, checkCredentials(ctx)...)
+	results = append(results, checkLogDir())
+	writeReport(w, results)
+	for _, result := range results {
+		if result.Status == checkFail {
+			return false
+		}
+	}
+	return true
+}
+
+func writeReport(w io.Writer, results []checkResult) {
+	for _, result := range results {
+		detail := strings.ReplaceAll(strings.TrimSpace(result.Detail), "\n", "\n      ")
+		fmt.Fprintf(w, "%s  %s: %s\n", result.Status, result.Name, detail)
+		if result.Hint != "" && result.Status != checkPass {
+			fmt.Fprintf(w, "      hint: %s\n", result.Hint)
+		}
+	}
+}
+
+var gitVersionPattern = regexp.MustCompile(`git version (\d+)\.(\d+)`)
+
+func parseGitVersion(s string) (major, minor int, ok bool) {
+	m := gitVersionPattern.FindStringSubmatch(s)
+	if m == nil {
+		return 0, 0, false
+	}
+	major, _ = strconv.Atoi(m[1])
+	minor, _ = strconv.Atoi(m[2])
+	return major, minor, true
+}
+
+func checkGit(ctx context.Context) checkResult {
+	result := checkResult{Name: "git"}
+	out, err := exec.CommandContext(ctx, "git", "--version").Output()
+	if err != nil {
+		result.Status, result.Detail = checkFail, err.Error()
+		result.Hint = "install git and make sure it is in PATH"
+		return result
+	}
+	result.Detail = strings.TrimSpace(string(out))
+	major, minor, ok := parseGitVersion(result.Detail)
+	if !ok {
+		result.Status = checkWarn
+		result.Hint = "unrecognised git version"
+	} else if major < MinGitVersion[0] || major == MinGitVersion[0] && minor < MinGitVersion[1] {
+		result.Status = checkFail
+		result.Hint = fmt.Sprintf("upgrade git to %d.%d or later", MinGitVersion[0], MinGitVersion[1])
+	}
+	return result
+}
+
+func checkRepo(ctx context.Context) checkResult {
+	result := checkResult{Name: "repository"}
+	out, err := exec.CommandContext(ctx, "git", "rev-parse", "--show-toplevel").Output()
+	if err != nil {
+		result.Status, result.Detail = checkFail, "not in a git work tree"
+		result.Hint = "run commitgpt doctor from inside the repository"
+		return result
+	}
+	result.Detail = strings.TrimSpace(string(out))
+	err = exec.CommandContext(ctx, "git", "diff", "--cached", "--quiet").Run()
+	var exitErr *exec.ExitError
+	switch {
+	case err == nil:
+		result.Detail += " (nothing staged)"
+	case errors.As(err, &exitErr) && exitErr.ExitCode() == 1:
+		result.Detail += " (changes staged)"
+	default:
+		result.Status = checkWarn
+		result.Detail += fmt.Sprintf(" (git diff --cached: %v)", err)
+	}
+	return result
+}
+
+func checkHook(ctx context.Context) checkResult {
+	result := checkResult{Name: "hook"}
+	out, err := exec.CommandContext(ctx, "git", "rev-parse", "--git-path", "hooks/prepare-commit-msg").Output()
+	if err != nil {
+		result.Status, result.Detail = checkFail, err.Error()
+		return result
+	}
+	path := strings.TrimSpace(string(out))
+	result.Detail = path
+	info, err := os.Stat(path)
+	if err != nil {
+		result.Status = checkFail
+		result.Detail = fmt.Sprintf("%s: not installed", path)
+		result.Hint = "create the prepare-commit-msg hook as described in the README"
+		return result
+	}
+	if info.Mode().Perm()&0o111 == 0 {
+		result.Status = checkFail
+		result.Detail = fmt.Sprintf("%s: not executable", path)
+		result.Hint = "chmod +x " + path
+		return result
+	}
+	if data, err := os.ReadFile(path); err == nil && !strings.Contains(string(data), "commitgpt") {
+		result.Status = checkWarn
+		result.Detail = fmt.Sprintf("%s: does not mention commitgpt", path)
+		result.Hint = "make sure the hook runs commitgpt with its arguments"
+	}
+	return result
+}
+
+func checkPandoc(ctx context.Context) checkResult {
+	result := checkResult{Name: "pandoc"}
+	if _, err := exec.LookPath("pandoc"); err != nil {
+		result.Status, result.Detail = checkFail, "not found in PATH"
+		result.Hint = "install pandoc, see https://pandoc.org/installing.html"
+		return result
+	}
+	out, err := exec.CommandContext(ctx, "pandoc", "--version").Output()
+	if err != nil {
+		result.Status, result.Detail = checkFail, err.Error()
+		return result
+	}
+	result.Detail, _, _ = strings.Cut(string(out), "\n")
+	return result
+}
+
+// checkConfig lists the settings in config, and fails with err, the error
+// from loading them.
+func checkConfig(config gitConfig, err error) checkResult {
+	result := checkResult{Name: "config"}
+	if err != nil {
+		result.Status, result.Detail = checkFail, err.Error()
+		result.Hint = "fix or unset the setting with git config"
+		return result
+	}
+	if len(config) == 0 {
+		result.Detail = "no commitgpt.* settings, using defaults"
+		return result
+	}
+	keys := make([]string, 0, len(config))
+	for key := range config {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var detail strings.Builder
+	for _, key := range keys {
+		for _, entry := range config[key] {
+			value := entry.Value
+			if strings.Contains(strings.ToLower(key), "header") {
+				// Headers often hold tokens.
+				value = "(hidden)"
+			}
+			fmt.Fprintf(&detail, "\n%s = %s (%s)", key, value, entry.Origin)
+		}
+	}
+	result.Detail = fmt.Sprintf("%d settings%s", len(keys), detail.String())
+	return result
+}
+
+// checkCredentials resolves the API key of every model in the chain, and
+// makes a dry request with each key that is found.
+func checkCredentials(ctx context.Context) (results []checkResult) {
+	for _, model := range Models {
+		result := checkResult{Name: "credentials " + model.String()}
+		apiKey, source, err := model.credentials().resolve(ctx)
+		if err != nil {
+			result.Status, result.Detail = checkFail, err.Error()
+			result.Hint = "set ANTHROPIC_API_KEY or commitgpt.apiKeyHelper, see the README"
+			results = append(results, result)
+			continue
+		}
+		result.Detail = "from " + source
+		results = append(results, result, checkEndpoint(ctx, model, apiKey))
+	}
+	return
+}
+
+// checkEndpoint counts the tokens of a short message, which checks the
+// endpoint, the key and the model without generating anything.
+func checkEndpoint(ctx context.Context, model modelSpec, apiKey string) checkResult {
+	url := strings.TrimSuffix(model.endpoint(), "/") + "/count_tokens"
+	result := checkResult{Name: "endpoint " + model.String()}
+	data := map[string]interface{}{
+		"model": model.Name,
+		"messages": []map[string]string{
+			{"role": "user", "content": "commitgpt doctor"},
+		},
+	}
+	var resp struct {
+		InputTokens int `json:"input_tokens"`
+	}
+	err := postAPI(ctx, model, apiKey, url, data, &resp)
+	if err != nil {
+		result.Status, result.Detail = checkFail, fmt.Sprintf("%s: %v", url, err)
+		var apiErr *apiError
+		switch {
+		case errors.As(err, &apiErr) && (apiErr.Type == "authentication_error" || apiErr.Type == "permission_error"):
+			result.Hint = "check that the API key is valid for this endpoint"
+		case errors.As(err, &apiErr) && apiErr.Type == "not_found_error":
+			result.Hint = "check the model name in commitgpt.model"
+		case errors.As(err, &apiErr):
+			result.Hint = "check commitgpt.header and the provider's endpoint"
+		default:
+			result.Hint = "check the network, commitgpt.proxy and commitgpt.caBundle"
+		}
+		return result
+	}
+	result.Detail = fmt.Sprintf("%s: reachable", url)
+	return result
+}
+
+func checkLogDir() checkResult {
+	result := checkResult{Name: "log directory"}
+	logDir := os.Getenv("ANTHROPIC_LOG_DIR")
+	if logDir == "" {
+		result.Detail = "ANTHROPIC_LOG_DIR is not set, messages are not logged"
+		return result
+	}
+	result.Detail = logDir
+	if err := os.MkdirAll(logDir, 0755); err != nil {
+		result.Status, result.Detail = checkFail, err.Error()
+		result.Hint = "create the directory or change ANTHROPIC_LOG_DIR"
+		return result
+	}
+	f, err := os.CreateTemp(logDir, ".doctor-*")
+	if err != nil {
+		result.Status, result.Detail = checkFail, err.Error()
+		result.Hint = "make the directory writable or change ANTHROPIC_LOG_DIR"
+		return result
+	}
+	f.Close()
+	os.Remove(f.Name())
+	return result
+}
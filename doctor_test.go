@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// chdirTempRepo changes to a new git repository for the rest of the test.
+func chdirTempRepo(t *testing.T) string {
+	dir := t.TempDir()
+	if out, err := exec.Command("git", "init", "-q", dir).CombinedOutput(); err != nil {
+		t.Fatalf("git init: %v: %s", err, out)
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+	return dir
+}
+
+func Test_parseGitVersion(t *testing.T) {
+	tests := []struct {
+		args         string
+		major, minor int
+		ok           bool
+	}{
+		{"git version 2.39.5", 2, 39, true},
+		{"git version 2.45.1.windows.1", 2, 45, true},
+		{"git version 1.8.3.1\n", 1, 8, true},
+		{"hub version 2.14.2", 0, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.args, func(t *testing.T) {
+			major, minor, ok := parseGitVersion(tt.args)
+			if major != tt.major || minor != tt.minor || ok != tt.ok {
+				t.Errorf("parseGitVersion() = %d, %d, %v, want %d, %d, %v", major, minor, ok, tt.major, tt.minor, tt.ok)
+			}
+		})
+	}
+}
+
+func Test_writeReport(t *testing.T) {
+	var got strings.Builder
+	writeReport(&got, []checkResult{
+		{Name: "git", Detail: "git version 2.39.5"},
+		{Name: "pandoc", Status: checkFail, Detail: "not found in PATH", Hint: "install pandoc"},
+		{Name: "config", Detail: "2 settings\na = 1 (file:x)\nb = 2 (file:y)", Hint: "not shown"},
+		{Name: "hook", Status: checkWarn, Detail: "hook: does not mention commitgpt", Hint: "check it"},
+	})
+	want := `PASS  git: git version 2.39.5
+FAIL  pandoc: not found in PATH
+      hint: install pandoc
+PASS  config: 2 settings
+      a = 1 (file:x)
+      b = 2 (file:y)
+WARN  hook: hook: does not mention commitgpt
+      hint: check it
+`
+	if diff := cmp.Diff(want, got.String()); diff != "" {
+		t.Errorf("writeReport() mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func Test_checkConfig(t *testing.T) {
+	config := gitConfig{
+		"commitgpt.model":          {{Value: "claude-a", Origin: "file:.git/config"}},
+		"commitgpt.gateway.header": {{Value: "X-Token: secret", Origin: "file:/home/me/.gitconfig"}},
+	}
+	tests := []struct {
+		name string
+		err  error
+		want checkResult
+	}{
+		{
+			name: "loaded",
+			want: checkResult{
+				Name:   "config",
+				Detail: "2 settings\ncommitgpt.gateway.header = (hidden) (file:/home/me/.gitconfig)\ncommitgpt.model = claude-a (file:.git/config)",
+			},
+		},
+		{
+			name: "error",
+			err:  errors.New(`commitgpt.timeout: time: invalid duration "soon"`),
+			want: checkResult{
+				Name:   "config",
+				Status: checkFail,
+				Detail: `commitgpt.timeout: time: invalid duration "soon"`,
+				Hint:   "fix or unset the setting with git config",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if diff := cmp.Diff(tt.want, checkConfig(config, tt.err)); diff != "" {
+				t.Errorf("checkConfig() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func Test_runDoctor_badConfig(t *testing.T) {
+	chdirTempRepo(t)
+	t.Setenv("ANTHROPIC_API_KEY", "")
+	defer func(timeout time.Duration) { Timeout = timeout }(Timeout)
+	if out, err := exec.Command("git", "config", "commitgpt.timeout", "soon").CombinedOutput(); err != nil {
+		t.Fatalf("git config: %v: %s", err, out)
+	}
+	var report strings.Builder
+	if runDoctor(context.Background(), &report) {
+		t.Error("runDoctor() = true, want false")
+	}
+	if !strings.Contains(report.String(), "FAIL  config: ") || !strings.Contains(report.String(), "soon") {
+		t.Errorf("runDoctor() report does not fail the config check:\n%s", report.String())
+	}
+}
+
+func Test_checkHook(t *testing.T) {
+	dir := chdirTempRepo(t)
+	hook := filepath.Join(".git", "hooks", "prepare-commit-msg")
+	os.MkdirAll(filepath.Dir(hook), 0755)
+
+	if got := checkHook(context.Background()); got.Status != checkFail || !strings.Contains(got.Detail, "not installed") {
+		t.Errorf("checkHook() = %+v, want not installed", got)
+	}
+
+	os.WriteFile(hook, []byte("#!/bin/sh\ncommitgpt \"$@\"\n"), 0644)
+	if got := checkHook(context.Background()); got.Status != checkFail || !strings.Contains(got.Detail, "not executable") {
+		t.Errorf("checkHook() = %+v, want not executable", got)
+	}
+
+	os.Chmod(hook, 0755)
+	if got := checkHook(context.Background()); got.Status != checkPass {
+		t.Errorf("checkHook() = %+v, want pass", got)
+	}
+
+	os.WriteFile(hook, []byte("#!/bin/sh\nexit 0\n"), 0755)
+	if got := checkHook(context.Background()); got.Status != checkWarn {
+		t.Errorf("checkHook() = %+v, want warning", got)
+	}
+
+	// core.hooksPath moves the hook.
+	exec.Command("git", "-C", dir, "config", "core.hooksPath", "hooks").Run()
+	if got := checkHook(context.Background()); got.Status != checkFail || strings.Contains(got.Detail, ".git") {
+		t.Errorf("checkHook() = %+v, want missing hook in core.hooksPath", got)
+	}
+}
+
+func Test_checkEndpoint(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/messages/count_tokens" {
+			t.Errorf("unexpected URL: %s", r.URL.Path)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if r.Header.Get("x-api-key") != "good-key" {
+			w.WriteHeader(http.StatusUnauthorized)
+			io.WriteString(w, `{"type":"error","error":{"type":"authentication_error","message":"invalid x-api-key"}}`)
+			return
+		}
+		io.WriteString(w, `{"input_tokens":12}`)
+	}))
+	defer ts.Close()
+	defer func(endpoint string) { Endpoint = endpoint }(Endpoint)
+	Endpoint = ts.URL + "/v1/messages"
+
+	got := checkEndpoint(context.Background(), modelSpec{Name: "claude"}, "good-key")
+	if got.Status != checkPass {
+		t.Errorf("checkEndpoint() = %+v, want pass", got)
+	}
+
+	got = checkEndpoint(context.Background(), modelSpec{Name: "claude"}, "bad-key")
+	if got.Status != checkFail || !strings.Contains(got.Detail, "authentication_error") || !strings.Contains(got.Hint, "API key") {
+		t.Errorf("checkEndpoint() = %+v, want authentication failure", got)
+	}
+}
+
+func Test_checkLogDir(t *testing.T) {
+	dir := t.TempDir()
+
+	t.Setenv("ANTHROPIC_LOG_DIR", "")
+	if got := checkLogDir(); got.Status != checkPass || !strings.Contains(got.Detail, "not set") {
+		t.Errorf("checkLogDir() = %+v, want pass when unset", got)
+	}
+
+	t.Setenv("ANTHROPIC_LOG_DIR", filepath.Join(dir, "logs"))
+	if got := checkLogDir(); got.Status != checkPass {
+		t.Errorf("checkLogDir() = %+v, want pass", got)
+	}
+	if entries, _ := os.ReadDir(filepath.Join(dir, "logs")); len(entries) != 0 {
+		t.Errorf("checkLogDir() left %d files behind", len(entries))
+	}
+
+	file := filepath.Join(dir, "file")
+	os.WriteFile(file, nil, 0644)
+	t.Setenv("ANTHROPIC_LOG_DIR", filepath.Join(file, "logs"))
+	if got := checkLogDir(); got.Status != checkFail || got.Hint == "" {
+		t.Errorf("checkLogDir() = %+v, want failure", got)
+	}
+}
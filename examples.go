@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strings"
+	"unicode/utf8"
+)
+
+// Examples is the number of recent commit messages to show the model as
+// examples of the repository's style. None are shown when it is 0.
+var Examples = 0
+
+// ExamplesTokens is roughly how many tokens the examples may use.
+var ExamplesTokens = 1000
+
+// ExampleFilters choose the commits that are used as examples:
+//
+//   - author: only the commits of the current user (user.email)
+//   - paths: only the commits that touched the directories of the staged files
+//   - quality: only the commits with a descriptive subject line
+var ExampleFilters = []string{"quality"}
+
+// exampleCandidates is how many recent commits are considered as examples.
+const exampleCandidates = 200
+
+// exampleCommit is a commit message from the history.
+type exampleCommit struct {
+	Author  string
+	Subject string
+	Body    string
+}
+
+func (c exampleCommit) String() string {
+	if c.Body == "" {
+		return c.Subject
+	}
+	return c.Subject + "\n\n" + c.Body
+}
+
+// styleExamples returns a prompt section with recent commit messages, chosen
+// by ExampleFilters, as examples of the style to follow. It is empty when
+// examples are disabled or none are found.
+func styleExamples(ctx context.Context, paths []string) (string, error) {
+	if Examples <= 0 {
+		return "", nil
+	}
+	filters := map[string]bool{}
+	for _, filter := range ExampleFilters {
+		filters[strings.ToLower(strings.TrimSpace(filter))] = true
+	}
+
+	if _, err := git(ctx, "rev-parse", "--verify", "--quiet", "HEAD"); err != nil {
+		// There is no history yet.
+		return "", nil
+	}
+	args := []string{"log", "--no-merges", "-z", fmt.Sprintf("-n%d", exampleCandidates), "--format=%ae%x1f%s%x1f%b"}
+	if filters["paths"] {
+		args = append(args, "--")
+		for _, dir := range pathDirs(paths) {
+			args = append(args, ":(top,literal)"+dir)
+		}
+	}
+	out, err := git(ctx, args...)
+	if err != nil {
+		return "", err
+	}
+	var email string
+	if filters["author"] {
+		user, err := git(ctx, "config", "user.email")
+		if err != nil {
+			return "", fmt.Errorf("filtering examples by author: user.email: %w", err)
+		}
+		email = strings.TrimSpace(string(user))
+	}
+
+	var examples []exampleCommit
+	budget := ExamplesTokens
+	for _, record := range splitNUL(out) {
+		fields := strings.SplitN(record, "\x1f", 3)
+		if len(fields) != 3 {
+			continue
+		}
+		commit := exampleCommit{
+			Author:  fields[0],
+			Subject: strings.TrimSpace(fields[1]),
+			Body:    strings.TrimSpace(fields[2]),
+		}
+		if filters["author"] && !strings.EqualFold(commit.Author, email) {
+			continue
+		}
+		if filters["quality"] && !goodExample(commit) {
+			continue
+		}
+		tokens := estimateTokens(commit.String())
+		if tokens > budget {
+			continue
+		}
+		budget -= tokens
+		examples = append(examples, commit)
+		if len(examples) == Examples {
+			break
+		}
+	}
+	if len(examples) == 0 {
+		return "", nil
+	}
+
+	var section strings.Builder
+	section.WriteString("<style-examples>\n")
+	section.WriteString("These are recent commit messages from this repository. Follow their conventions, such as\n")
+	section.WriteString("the types and scopes, capitalisation, tense and line length, but describe only the diff above.\n")
+	for _, example := range examples {
+		fmt.Fprintf(&section, "<commit-message>\n%s\n</commit-message>\n", example)
+	}
+	section.WriteString("</style-examples>\n")
+	return section.String(), nil
+}
+
+// pathDirs returns the directories of paths, or the path itself for files at
+// the top of the repository.
+func pathDirs(paths []string) []string {
+	seen := map[string]bool{}
+	var dirs []string
+	for _, p := range paths {
+		dir := path.Dir(p)
+		if dir == "." {
+			dir = p
+		}
+		if !seen[dir] {
+			seen[dir] = true
+			dirs = append(dirs, dir)
+		}
+	}
+	return dirs
+}
+
+// goodExample reports whether a commit message is worth imitating: a subject
+// of a few words that fits on a line, and not a work in progress.
+func goodExample(c exampleCommit) bool {
+	if wipPattern.MatchString(c.Subject) {
+		return false
+	}
+	subject := strings.ToLower(c.Subject)
+	for _, prefix := range []string{"merge ", "revert \""} {
+		if strings.HasPrefix(subject, prefix) {
+			return false
+		}
+	}
+	length := utf8.RuneCountInString(c.Subject)
+	return length >= 10 && length <= 72 && len(strings.Fields(c.Subject)) >= 3
+}
+
+// estimateTokens estimates the tokens in s, at about four characters each.
+func estimateTokens(s string) int {
+	return (len(s) + 3) / 4
+}
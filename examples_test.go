@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"os/exec"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func Test_goodExample(t *testing.T) {
+	tests := []struct {
+		subject string
+		want    bool
+	}{
+		{"fix(api): retry overloaded requests", true},
+		{"Add a doctor command", true},
+		{"wip", false},
+		{"WIP: half of the parser", false},
+		{"fixup! Add a doctor command", false},
+		{"temp: skip the flaky test", false},
+		{"template: add the changelog prompt", true},
+		{"temperature: lower it for the review", true},
+		{"Merge branch 'main' into feature", false},
+		{"update", false},
+		{"fix typo", false},
+		{"feat: " + strings.Repeat("very ", 14) + "long", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.subject, func(t *testing.T) {
+			if got := goodExample(exampleCommit{Subject: tt.subject}); got != tt.want {
+				t.Errorf("goodExample() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_pathDirs(t *testing.T) {
+	got := pathDirs([]string{"api/a.go", "api/b.go", "go.mod", "web/src/app.ts"})
+	want := []string{"api", "go.mod", "web/src"}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("pathDirs() mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func Test_styleExamples(t *testing.T) {
+	defer func(n, tokens int, filters []string) {
+		Examples, ExamplesTokens, ExampleFilters = n, tokens, filters
+	}(Examples, ExamplesTokens, ExampleFilters)
+	chdirTempRepo(t)
+	exec.Command("git", "config", "user.email", "me@example.com").Run()
+	commits := []struct {
+		email, file, message string
+	}{
+		{"me@example.com", "api/a.go", "feat(api): add the first endpoint\n\nIt returns the time."},
+		{"other@example.com", "web/app.ts", "feat(web): show the time on the page"},
+		{"me@example.com", "api/b.go", "wip"},
+		{"me@example.com", "docs/readme.md", "docs: describe the endpoints"},
+	}
+	for _, c := range commits {
+		stageFiles(t, map[string]string{c.file: c.message})
+		cmd := exec.Command("git", "-c", "user.name=x", "-c", "user.email="+c.email, "commit", "-q", "-m", c.message)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git commit: %v: %s", err, out)
+		}
+	}
+
+	tests := []struct {
+		name    string
+		n       int
+		tokens  int
+		filters []string
+		want    []string
+	}{
+		{
+			name:    "disabled",
+			filters: []string{"quality"},
+		},
+		{
+			name:    "quality",
+			n:       5,
+			tokens:  1000,
+			filters: []string{"quality"},
+			want:    []string{"docs: describe the endpoints", "feat(web): show the time on the page", "feat(api): add the first endpoint\n\nIt returns the time."},
+		},
+		{
+			name:    "sample size",
+			n:       1,
+			tokens:  1000,
+			filters: []string{"quality"},
+			want:    []string{"docs: describe the endpoints"},
+		},
+		{
+			name:    "author and paths",
+			n:       5,
+			tokens:  1000,
+			filters: []string{"author", "paths"},
+			want:    []string{"wip", "feat(api): add the first endpoint\n\nIt returns the time."},
+		},
+		{
+			name:    "token budget",
+			n:       5,
+			tokens:  17,
+			filters: []string{"quality"},
+			want:    []string{"docs: describe the endpoints", "feat(web): show the time on the page"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			Examples, ExamplesTokens, ExampleFilters = tt.n, tt.tokens, tt.filters
+			got, err := styleExamples(context.Background(), []string{"api/c.go"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			var messages []string
+			for _, part := range strings.Split(got, "<commit-message>\n")[1:] {
+				message, _, _ := strings.Cut(part, "\n</commit-message>")
+				messages = append(messages, message)
+			}
+			if diff := cmp.Diff(tt.want, messages); diff != "" {
+				t.Errorf("styleExamples() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// The stages of the hook that may fail. Each has its own failure policy.
+const (
+	stageConfig      = "config"
+	stageTemplate    = "template"
+	stageGit         = "git"
+	stageCredentials = "credentials"
+	stageAPI         = "api"
+	stageFormat      = "format"
+	stageSensitive   = "sensitive"
+	stageWrite       = "write"
+	stageLog         = "log"
+	stageTimeout     = "timeout"
+)
+
+// stageError is an error from one stage of the hook.
+type stageError struct {
+	Stage string
+	Err   error
+}
+
+func (e *stageError) Error() string { return e.Err.Error() }
+
+func (e *stageError) Unwrap() error { return e.Err }
+
+// failurePolicy is what the hook does when a stage fails.
+type failurePolicy string
+
+const (
+	// policyAbort exits with an error, which aborts the commit.
+	policyAbort failurePolicy = "abort"
+	// policyContinue leaves the commit message file alone and lets the
+	// commit go ahead.
+	policyContinue failurePolicy = "continue"
+	// policyComment adds a comment explaining the failure to the commit
+	// message file and lets the commit go ahead.
+	policyComment failurePolicy = "comment"
+)
+
+// FailurePolicies maps each stage to its policy, configured with
+// commitgpt.policy.<stage>.
+var FailurePolicies = map[string]failurePolicy{
+	stageConfig:      policyAbort,
+	stageTemplate:    policyAbort,
+	stageGit:         policyAbort,
+	stageCredentials: policyContinue,
+	stageAPI:         policyAbort,
+	stageFormat:      policyContinue,
+	stageSensitive:   policyAbort,
+	stageWrite:       policyAbort,
+	stageLog:         policyContinue,
+	stageTimeout:     policyComment,
+}
+
+func parseFailurePolicy(s string) (failurePolicy, error) {
+	switch p := failurePolicy(strings.ToLower(strings.TrimSpace(s))); p {
+	case policyAbort, policyContinue, policyComment:
+		return p, nil
+	}
+	return "", fmt.Errorf("unknown failure policy %q: want abort, continue or comment", s)
+}
+
+// loadFailurePolicies reads commitgpt.policy.<stage>, or
+// COMMITGPT_POLICY_<STAGE>, for every stage.
+func loadFailurePolicies(c gitConfig) error {
+	for stage, policy := range FailurePolicies {
+		key := "commitgpt.policy." + stage
+		v := c.String(key, "COMMITGPT_POLICY_"+strings.ToUpper(stage), string(policy))
+		policy, err := parseFailurePolicy(v)
+		if err != nil {
+			return fmt.Errorf("%s: %w", key, err)
+		}
+		FailurePolicies[stage] = policy
+	}
+	return nil
+}
+
+// handleFailure reports err according to the policy of the stage that failed
+// and returns the exit code of the hook. Errors without a stage abort. An
+// interrupted hook always aborts.
+func handleFailure(ctx context.Context, commitMsgFile string, err error) int {
+	if err == nil {
+		return 0
+	}
+	switch ctx.Err() {
+	case context.Canceled:
+		fmt.Fprintln(os.Stderr, "commitgpt: interrupted")
+		return 1
+	case context.DeadlineExceeded:
+		err = &stageError{Stage: stageTimeout, Err: fmt.Errorf("no message was generated within %s (commitgpt.timeout)", Timeout)}
+	}
+
+	var se *stageError
+	if !errors.As(err, &se) {
+		fmt.Fprintln(os.Stderr, "commitgpt:", err)
+		return 1
+	}
+	fmt.Fprintf(os.Stderr, "commitgpt: %s failed: %v\n", se.Stage, se.Err)
+	note := fmt.Sprintf("commitgpt: %s failed: %s", se.Stage, strings.Join(strings.Fields(se.Err.Error()), " "))
+
+	switch FailurePolicies[se.Stage] {
+	case policyContinue:
+		return 0
+	case policyComment:
+		if cleanupKeepsComments() {
+			// git would keep the note in the commit.
+			return 0
+		}
+		content, err := os.ReadFile(commitMsgFile)
+		if err == nil {
+			err = os.WriteFile(commitMsgFile, []byte(annotateTemplate(string(content), note)), 0644)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "commitgpt:", err)
+			return 1
+		}
+		return 0
+	}
+	return 1
+}
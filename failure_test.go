@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// resetFailurePolicies restores the default policies when the test is done.
+func resetFailurePolicies(t *testing.T) {
+	policies := map[string]failurePolicy{}
+	for stage, policy := range FailurePolicies {
+		policies[stage] = policy
+	}
+	t.Cleanup(func() { FailurePolicies = policies })
+}
+
+func Test_loadFailurePolicies(t *testing.T) {
+	resetFailurePolicies(t)
+	t.Setenv("COMMITGPT_POLICY_GIT", "Comment")
+	err := loadFailurePolicies(gitConfig{
+		"commitgpt.policy.api": {{Value: "continue"}},
+		"commitgpt.policy.git": {{Value: "abort"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := FailurePolicies[stageAPI]; got != policyContinue {
+		t.Errorf("api policy = %q, want %q", got, policyContinue)
+	}
+	if got := FailurePolicies[stageGit]; got != policyComment {
+		t.Errorf("git policy = %q, want %q", got, policyComment)
+	}
+	if got := FailurePolicies[stageWrite]; got != policyAbort {
+		t.Errorf("write policy = %q, want %q", got, policyAbort)
+	}
+
+	err = loadFailurePolicies(gitConfig{"commitgpt.policy.log": {{Value: "ignore"}}})
+	if err == nil {
+		t.Error("loadFailurePolicies() expected error for unknown policy")
+	}
+}
+
+func Test_handleFailure(t *testing.T) {
+	resetFailurePolicies(t)
+	const template = "\n# Please enter the commit message for your changes.\n"
+	deadline, cancel := context.WithTimeout(context.Background(), 0)
+	defer cancel()
+	interrupted, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	tests := []struct {
+		name     string
+		ctx      context.Context
+		policy   failurePolicy
+		err      error
+		wantCode int
+		want     string
+	}{
+		{
+			name: "success",
+			ctx:  context.Background(),
+			want: template,
+		},
+		{
+			name:     "abort",
+			ctx:      context.Background(),
+			policy:   policyAbort,
+			err:      &stageError{Stage: stageAPI, Err: errors.New("error: overloaded_error: Overloaded")},
+			wantCode: 1,
+			want:     template,
+		},
+		{
+			name:   "continue",
+			ctx:    context.Background(),
+			policy: policyContinue,
+			err:    &stageError{Stage: stageAPI, Err: errors.New("error: overloaded_error: Overloaded")},
+			want:   template,
+		},
+		{
+			name:   "comment",
+			ctx:    context.Background(),
+			policy: policyComment,
+			err:    &stageError{Stage: stageAPI, Err: errors.New("error: overloaded_error:\nOverloaded")},
+			want:   "\n# commitgpt: api failed: error: overloaded_error: Overloaded\n# Please enter the commit message for your changes.\n",
+		},
+		{
+			name:     "no stage",
+			ctx:      context.Background(),
+			policy:   policyContinue,
+			err:      errors.New("boom"),
+			wantCode: 1,
+			want:     template,
+		},
+		{
+			name:   "timeout",
+			ctx:    deadline,
+			policy: policyAbort,
+			err:    &stageError{Stage: stageAPI, Err: context.DeadlineExceeded},
+			want:   "\n# commitgpt: timeout failed: no message was generated within 2m0s (commitgpt.timeout)\n# Please enter the commit message for your changes.\n",
+		},
+		{
+			name:     "interrupted",
+			ctx:      interrupted,
+			policy:   policyComment,
+			err:      &stageError{Stage: stageAPI, Err: context.Canceled},
+			wantCode: 1,
+			want:     template,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			commitMsgFile := filepath.Join(t.TempDir(), "COMMIT_EDITMSG")
+			if err := os.WriteFile(commitMsgFile, []byte(template), 0644); err != nil {
+				t.Fatal(err)
+			}
+			FailurePolicies[stageAPI] = tt.policy
+
+			if got := handleFailure(tt.ctx, commitMsgFile, tt.err); got != tt.wantCode {
+				t.Errorf("handleFailure() = %d, want %d", got, tt.wantCode)
+			}
+			got, err := os.ReadFile(commitMsgFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(tt.want, string(got)); diff != "" {
+				t.Errorf("commit message mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func Test_makeAPICall_stage(t *testing.T) {
+	defer func(models []modelSpec, providers map[string]provider) {
+		Models, Providers = models, providers
+	}(Models, Providers)
+	Providers = map[string]provider{"other": {APIKeyEnv: "OTHER_API_KEY"}}
+	Models = []modelSpec{{Provider: "other", Name: "claude"}}
+	t.Setenv("OTHER_API_KEY", "")
+
+	_, err := makeAPICall(context.Background(), "main", "diff")
+	var se *stageError
+	if !errors.As(err, &se) || se.Stage != stageCredentials {
+		t.Errorf("makeAPICall() err = %#v, want %s stage", err, stageCredentials)
+	}
+}
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/printer"
+	"go/token"
+	"path"
+	"sort"
+	"strings"
+)
+
+// goDecl is an exported function, method or type.
+type goDecl struct {
+	Kind      string // func, method or type
+	Name      string // Name, or (Recv).Name for methods
+	Signature string
+	Body      string
+	fields    map[string]string // exported fields of a struct type
+	isStruct  bool
+}
+
+func (d goDecl) String() string {
+	if d.Kind == "type" {
+		// The definition of a struct or interface spans several lines.
+		return "type " + d.Name
+	}
+	return d.Kind + " " + d.Signature
+}
+
+// goAPIChange is an exported declaration that was added, removed or modified.
+type goAPIChange struct {
+	Description string
+	Breaking    bool
+}
+
+func (c goAPIChange) String() string {
+	if c.Breaking {
+		return c.Description + " [BREAKING CHANGE candidate]"
+	}
+	return c.Description
+}
+
+// parseGoDecls returns the exported functions, methods and types of a Go
+// source file, by kind and name, and its package name.
+func parseGoDecls(src []byte) (map[string]goDecl, string, error) {
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "", src, parser.SkipObjectResolution)
+	if err != nil {
+		return nil, "", err
+	}
+	render := func(node ast.Node) string {
+		var buf bytes.Buffer
+		printer.Fprint(&buf, fset, node)
+		return buf.String()
+	}
+	decls := map[string]goDecl{}
+	for _, decl := range file.Decls {
+		switch decl := decl.(type) {
+		case *ast.FuncDecl:
+			if !decl.Name.IsExported() {
+				continue
+			}
+			d := goDecl{Kind: "func", Name: decl.Name.Name}
+			if decl.Recv != nil && len(decl.Recv.List) > 0 {
+				recv := decl.Recv.List[0].Type
+				if !ast.IsExported(receiverName(recv)) {
+					continue
+				}
+				d.Kind = "method"
+				d.Name = fmt.Sprintf("(%s).%s", render(recv), decl.Name.Name)
+			}
+			d.Signature = d.Name + strings.TrimPrefix(render(decl.Type), "func")
+			if decl.Body != nil {
+				d.Body = render(decl.Body)
+			}
+			decls[d.Kind+" "+d.Name] = d
+		case *ast.GenDecl:
+			if decl.Tok != token.TYPE {
+				continue
+			}
+			for _, spec := range decl.Specs {
+				spec := spec.(*ast.TypeSpec)
+				if !spec.Name.IsExported() {
+					continue
+				}
+				d := goDecl{Kind: "type", Name: spec.Name.Name, Signature: render(spec)}
+				if st, ok := spec.Type.(*ast.StructType); ok {
+					d.isStruct, d.fields = true, map[string]string{}
+					for _, field := range st.Fields.List {
+						names := field.Names
+						if len(names) == 0 {
+							names = []*ast.Ident{{Name: receiverName(field.Type)}}
+						}
+						for _, name := range names {
+							if ast.IsExported(name.Name) {
+								d.fields[name.Name] = render(field.Type)
+							}
+						}
+					}
+				}
+				decls["type "+d.Name] = d
+			}
+		}
+	}
+	return decls, file.Name.Name, nil
+}
+
+// receiverName returns the name of the type in a receiver or embedded field
+// such as *List[T].
+func receiverName(expr ast.Expr) string {
+	for {
+		switch e := expr.(type) {
+		case *ast.StarExpr:
+			expr = e.X
+		case *ast.IndexExpr:
+			expr = e.X
+		case *ast.IndexListExpr:
+			expr = e.X
+		case *ast.SelectorExpr:
+			return e.Sel.Name
+		case *ast.Ident:
+			return e.Name
+		default:
+			return ""
+		}
+	}
+}
+
+// diffGoDecls compares the exported declarations of two versions of a
+// package. Removals and incompatible changes are breaking unless public is
+// false.
+func diffGoDecls(before, after map[string]goDecl, public bool) []goAPIChange {
+	keys := map[string]bool{}
+	for key := range before {
+		keys[key] = true
+	}
+	for key := range after {
+		keys[key] = true
+	}
+	sorted := make([]string, 0, len(keys))
+	for key := range keys {
+		sorted = append(sorted, key)
+	}
+	sort.Strings(sorted)
+
+	var changes []goAPIChange
+	for _, key := range sorted {
+		prev, hadPrev := before[key]
+		cur, hasCur := after[key]
+		switch {
+		case !hadPrev:
+			changes = append(changes, goAPIChange{Description: "added " + cur.String()})
+		case !hasCur:
+			changes = append(changes, goAPIChange{Description: "removed " + prev.String(), Breaking: public})
+		case prev.Signature != cur.Signature && cur.Kind == "type":
+			changes = append(changes, goAPIChange{
+				Description: "changed definition of " + cur.String(),
+				Breaking:    public && !compatibleStruct(prev, cur),
+			})
+		case prev.Signature != cur.Signature:
+			changes = append(changes, goAPIChange{
+				Description: fmt.Sprintf("changed signature of %s %s to %s", cur.Kind, prev.Signature, cur.Signature),
+				Breaking:    public,
+			})
+		case prev.Body != cur.Body:
+			changes = append(changes, goAPIChange{Description: fmt.Sprintf("modified body of %s %s", cur.Kind, cur.Name)})
+		}
+	}
+	return changes
+}
+
+// compatibleStruct reports whether after only adds fields to the struct
+// before, or changes unexported ones.
+func compatibleStruct(before, after goDecl) bool {
+	if !before.isStruct || !after.isStruct {
+		return false
+	}
+	for name, typ := range before.fields {
+		if after.fields[name] != typ {
+			return false
+		}
+	}
+	return true
+}
+
+// goPackageChanges are the changes to the exported declarations of a Go
+// package.
+type goPackageChanges struct {
+	Dir     string
+	Package string
+	Public  bool
+	Changes []goAPIChange
+}
+
+// goPackage is a package by its directory and name, as a directory may hold
+// more than one, such as a main package behind a build tag.
+type goPackage struct {
+	Dir, Name string
+}
+
+// goAPIChanges returns the changes in r to the exported declarations of the
+// packages of the Go files in paths. The declarations of each package are
+// compared as a whole, so that one moved to another file is not a change.
+// Files that do not parse are skipped, as are tests.
+func goAPIChanges(ctx context.Context, r revRange, paths []string) []goPackageChanges {
+	var pkgs []goPackage
+	before, after := map[goPackage]map[string]goDecl{}, map[goPackage]map[string]goDecl{}
+	add := func(decls map[goPackage]map[string]goDecl, pkg goPackage, file map[string]goDecl) {
+		if before[pkg] == nil && after[pkg] == nil {
+			pkgs = append(pkgs, pkg)
+		}
+		if decls[pkg] == nil {
+			decls[pkg] = map[string]goDecl{}
+		}
+		for key, decl := range file {
+			decls[pkg][key] = decl
+		}
+	}
+	for _, p := range paths {
+		if !strings.HasSuffix(p, ".go") || strings.HasSuffix(p, "_test.go") {
+			continue
+		}
+		var oldDecls, newDecls map[string]goDecl
+		var oldPkg, newPkg string
+		var err error
+		oldSrc, newSrc := r.blobs(ctx, p)
+		if oldSrc != nil {
+			if oldDecls, oldPkg, err = parseGoDecls(oldSrc); err != nil {
+				continue
+			}
+		}
+		if newSrc != nil {
+			if newDecls, newPkg, err = parseGoDecls(newSrc); err != nil {
+				continue
+			}
+		}
+		if oldSrc != nil {
+			add(before, goPackage{Dir: path.Dir(p), Name: oldPkg}, oldDecls)
+		}
+		if newSrc != nil {
+			add(after, goPackage{Dir: path.Dir(p), Name: newPkg}, newDecls)
+		}
+	}
+
+	var changes []goPackageChanges
+	for _, pkg := range pkgs {
+		public := pkg.Name != "main" && !isInternal(pkg.Dir)
+		if c := diffGoDecls(before[pkg], after[pkg], public); len(c) > 0 {
+			changes = append(changes, goPackageChanges{Dir: pkg.Dir, Package: pkg.Name, Public: public, Changes: c})
+		}
+	}
+	return changes
+}
+
+// summariseGoAPI lists the changes in r to the exported declarations of the
+// packages of the Go files in paths.
+func summariseGoAPI(ctx context.Context, r revRange, paths []string) string {
+	var summary strings.Builder
+	for _, pkg := range goAPIChanges(ctx, r, paths) {
+		fmt.Fprintf(&summary, "Go API changes in %s (package %s):\n", pkg.Dir, pkg.Package)
+		for _, change := range pkg.Changes {
+			fmt.Fprintf(&summary, "- %s\n", change)
+		}
+	}
+	return summary.String()
+}
+
+// isInternal reports whether p is in an internal package, which cannot be
+// imported from outside its module.
+func isInternal(p string) bool {
+	for _, dir := range strings.Split(p, "/") {
+		if dir == "internal" {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func Test_diffGoDecls(t *testing.T) {
+	before := `package store
+
+// Store keeps things.
+type Store struct {
+	Name  string
+	Limit int
+	cache map[string]string
+}
+
+type Options struct {
+	Path string
+}
+
+type Reader interface {
+	Read(key string) (string, error)
+}
+
+func Open(path string) (*Store, error) { return &Store{}, nil }
+
+func (s *Store) Get(key string) string { return s.cache[key] }
+
+func (s *Store) Close() error { return nil }
+
+func Legacy() {}
+
+func helper() {}
+`
+	after := `package store
+
+// Store keeps things, now with a size.
+type Store struct {
+	Name  string
+	Limit int
+	Size  int
+	cache map[string]int
+}
+
+type Options struct {
+	Path []string
+}
+
+type Reader interface {
+	Read(key string) (string, error)
+}
+
+func Open(path string, opts Options) (*Store, error) { return &Store{}, nil }
+
+// Get gets a thing.
+func (s *Store) Get(key string) string {
+	return s.cache[key]
+}
+
+func (s *Store) Close() error {
+	s.cache = nil
+	return nil
+}
+
+func (l *List[T]) Len() int { return 0 }
+
+func (s *store) Hidden() {}
+
+func helper(x int) {}
+`
+	prev, _, err := parseGoDecls([]byte(before))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cur, pkg, err := parseGoDecls([]byte(after))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pkg != "store" {
+		t.Errorf("parseGoDecls() package = %q, want store", pkg)
+	}
+
+	var got []string
+	for _, change := range diffGoDecls(prev, cur, true) {
+		got = append(got, change.String())
+	}
+	want := []string{
+		"removed func Legacy() [BREAKING CHANGE candidate]",
+		"changed signature of func Open(path string) (*Store, error) to Open(path string, opts Options) (*Store, error) [BREAKING CHANGE candidate]",
+		"added method (*List[T]).Len() int",
+		"modified body of method (*Store).Close",
+		"changed definition of type Options [BREAKING CHANGE candidate]",
+		"changed definition of type Store",
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("diffGoDecls() mismatch (-want +got):\n%s", diff)
+	}
+
+	for _, change := range diffGoDecls(prev, cur, false) {
+		if change.Breaking {
+			t.Errorf("diffGoDecls() not public: %s", change)
+		}
+	}
+}
+
+func Test_summariseGoAPI(t *testing.T) {
+	chdirTempRepo(t)
+	stageFiles(t, map[string]string{
+		"api/api.go":       "package api\n\nfunc Old() {}\n",
+		"internal/x/x.go":  "package x\n\nfunc Old() {}\n",
+		"api/api_test.go":  "package api\n\nfunc TestOld() {}\n",
+		"cmd/tool/main.go": "package main\n\nfunc Run() {}\n",
+		"api/broken.go":    "package api\n\nfunc Ok() {}\n",
+	})
+	commitStaged(t, "init")
+	stageFiles(t, map[string]string{
+		"api/api.go":       "package api\n\nfunc New() {}\n",
+		"internal/x/x.go":  "package x\n\nfunc New() {}\n",
+		"api/api_test.go":  "package api\n\nfunc TestNew() {}\n",
+		"cmd/tool/main.go": "package main\n\nfunc Run(args []string) {}\n",
+		"api/broken.go":    "package api\n\nfunc Ok( {}\n",
+	})
+	os.Remove("api/api.go")
+	stageFiles(t, nil)
+
+	got := summariseGoAPI(context.Background(), staged, []string{"api/api.go", "internal/x/x.go", "api/api_test.go", "cmd/tool/main.go", "api/broken.go"})
+	want := `Go API changes in api (package api):
+- removed func Old() [BREAKING CHANGE candidate]
+Go API changes in internal/x (package x):
+- added func New()
+- removed func Old()
+Go API changes in cmd/tool (package main):
+- changed signature of func Run() to Run(args []string)
+`
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("summariseGoAPI() mismatch (-want +got):\n%s", diff)
+	}
+	if strings.Contains(got, "broken.go") {
+		t.Error("summariseGoAPI() summarised a file that does not parse")
+	}
+}
+
+func Test_goAPIChanges_moved(t *testing.T) {
+	chdirTempRepo(t)
+	stageFiles(t, map[string]string{
+		"api/client.go": "package api\n\ntype Client struct{}\n\nfunc Dial() *Client { return nil }\n",
+		"api/old.go":    "package api\n\nfunc Retry() {}\n",
+	})
+	commitStaged(t, "init")
+	// Dial moves to a new file, and Retry to a renamed one.
+	stageFiles(t, map[string]string{
+		"api/client.go": "package api\n\ntype Client struct{}\n",
+		"api/dial.go":   "package api\n\nfunc Dial() *Client { return nil }\n",
+		"api/retry.go":  "package api\n\nfunc Retry() {}\n",
+	})
+	os.Remove("api/old.go")
+	stageFiles(t, nil)
+
+	paths, err := rangePaths(context.Background(), staged)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := goAPIChanges(context.Background(), staged, paths); len(got) != 0 {
+		t.Errorf("goAPIChanges() = %+v, want no changes", got)
+	}
+}
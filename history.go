@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// BranchContext is how much of the commits already on the branch is shown to
+// the model: "off", "subjects" or "bodies".
+var BranchContext = "subjects"
+
+// Upstream is the branch that the current branch started from. When it is
+// empty, the upstream of the current branch is used, if it has one.
+var Upstream = ""
+
+// branchHistoryLimit is the most commits on the branch that are shown.
+const branchHistoryLimit = 50
+
+// branchHistory returns a prompt section with the commits between the merge
+// base of HEAD and Upstream, and HEAD, oldest first. It is empty when there
+// are none.
+func branchHistory(ctx context.Context) (string, error) {
+	if BranchContext == "off" {
+		return "", nil
+	}
+	upstream := Upstream
+	if upstream == "" {
+		if _, err := git(ctx, "rev-parse", "--verify", "--quiet", "@{upstream}"); err != nil {
+			// No upstream is configured, or there is no HEAD yet.
+			return "", nil
+		}
+		upstream = "@{upstream}"
+	}
+	base, err := git(ctx, "merge-base", "HEAD", upstream)
+	if err != nil {
+		return "", fmt.Errorf("merge base of HEAD and %s: %w", upstream, err)
+	}
+	out, err := git(ctx, "log", "-z", "--reverse", fmt.Sprintf("-n%d", branchHistoryLimit),
+		"--format=%s%x1f%b", strings.TrimSpace(string(base))+"..HEAD")
+	if err != nil {
+		return "", err
+	}
+	commits := splitNUL(out)
+	if len(commits) == 0 {
+		return "", nil
+	}
+
+	var section strings.Builder
+	section.WriteString("<branch-history>\n")
+	fmt.Fprintf(&section, "These commits are already on the branch since it left %s, oldest first. Describe only\n", upstream)
+	section.WriteString("the step that the diff above adds to them, and reuse their scope where it fits.\n")
+	for _, commit := range commits {
+		subject, body, _ := strings.Cut(commit, "\x1f")
+		fmt.Fprintf(&section, "- %s\n", strings.TrimSpace(subject))
+		if body = strings.TrimSpace(body); body != "" && BranchContext == "bodies" {
+			fmt.Fprintf(&section, "  %s\n", strings.ReplaceAll(body, "\n", "\n  "))
+		}
+	}
+	section.WriteString("</branch-history>\n")
+	return section.String(), nil
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"os/exec"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func Test_branchHistory(t *testing.T) {
+	defer func(branchContext, upstream string) {
+		BranchContext, Upstream = branchContext, upstream
+	}(BranchContext, Upstream)
+	chdirTempRepo(t)
+	exec.Command("git", "checkout", "-q", "-b", "main").Run()
+	stageFiles(t, map[string]string{"a.go": "package a\n"})
+	commitStaged(t, "feat: start")
+	exec.Command("git", "checkout", "-q", "-b", "feature").Run()
+	stageFiles(t, map[string]string{"b.go": "package a\n"})
+	commitStaged(t, "feat(b): add b\n\nIt is like a.\nBut b.")
+	stageFiles(t, map[string]string{"c.go": "package a\n"})
+	commitStaged(t, "feat(b): add c")
+	exec.Command("git", "checkout", "-q", "main").Run()
+	stageFiles(t, map[string]string{"d.go": "package a\n"})
+	commitStaged(t, "feat: on main since")
+	exec.Command("git", "checkout", "-q", "feature").Run()
+
+	tests := []struct {
+		name     string
+		context  string
+		upstream string
+		want     string
+		wantErr  bool
+	}{
+		{
+			name:     "subjects",
+			context:  "subjects",
+			upstream: "main",
+			want: `<branch-history>
+These commits are already on the branch since it left main, oldest first. Describe only
+the step that the diff above adds to them, and reuse their scope where it fits.
+- feat(b): add b
+- feat(b): add c
+</branch-history>
+`,
+		},
+		{
+			name:     "bodies",
+			context:  "bodies",
+			upstream: "main",
+			want: `<branch-history>
+These commits are already on the branch since it left main, oldest first. Describe only
+the step that the diff above adds to them, and reuse their scope where it fits.
+- feat(b): add b
+  It is like a.
+  But b.
+- feat(b): add c
+</branch-history>
+`,
+		},
+		{
+			name:     "off",
+			context:  "off",
+			upstream: "main",
+		},
+		{
+			name:    "no upstream",
+			context: "subjects",
+		},
+		{
+			name:     "same branch",
+			context:  "subjects",
+			upstream: "feature",
+		},
+		{
+			name:     "unknown upstream",
+			context:  "subjects",
+			upstream: "origin/main",
+			wantErr:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			BranchContext, Upstream = tt.context, tt.upstream
+			got, err := branchHistory(context.Background())
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("branchHistory() err = %v, want error %v", err, tt.wantErr)
+			}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("branchHistory() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func Test_promptSections_unknownUpstream(t *testing.T) {
+	defer func(branchContext, upstream string) {
+		BranchContext, Upstream = branchContext, upstream
+	}(BranchContext, Upstream)
+	chdirTempRepo(t)
+	stageFiles(t, map[string]string{"a.go": "package a\n"})
+	commitStaged(t, "feat: start")
+	BranchContext, Upstream = "subjects", "origin/main"
+
+	sections, err := promptSections(context.Background(), []string{"a.go"}, nil)
+	if err != nil {
+		t.Fatalf("promptSections() err = %v, want the history left out", err)
+	}
+	for _, section := range sections {
+		if strings.Contains(section, "<branch-history>") {
+			t.Errorf("promptSections() = %q, want no branch history", sections)
+		}
+	}
+}
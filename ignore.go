@@ -0,0 +1,267 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ignoreFile lists paths, in gitignore syntax, whose changes are not sent to
+// the model.
+const ignoreFile = ".commitgptignore"
+
+// pathMode is how the changes to a staged path are sent to the model.
+type pathMode int
+
+const (
+	pathInclude pathMode = iota
+	pathSummary
+	pathDeps
+	pathExclude
+)
+
+// revRange is the changes between the commits Base and Head. The zero value
+// is the staged changes.
+type revRange struct {
+	Base, Head string
+}
+
+// staged is the changes staged in the index.
+var staged = revRange{}
+
+// diff returns the arguments to git that run command, such as diff or
+// check-attr, on the changes in r, followed by args.
+func (r revRange) diff(args ...string) []string {
+	if r == staged {
+		return append([]string{"diff", "--cached"}, args...)
+	}
+	return append([]string{"diff", r.Base, r.Head}, args...)
+}
+
+// blobs returns the contents of the file p before and after the changes in r.
+// Either is nil when the file does not exist.
+func (r revRange) blobs(ctx context.Context, p string) (before, after []byte) {
+	base, head := r.Base, r.Head
+	if r == staged {
+		base = "HEAD"
+		if _, err := git(ctx, "rev-parse", "--verify", "--quiet", "HEAD"); err != nil {
+			base = ""
+		}
+	}
+	// The file is new when it is not in base, and deleted when it is not in
+	// head, or the index.
+	if base != "" {
+		if data, err := git(ctx, "cat-file", "blob", base+":"+p); err == nil {
+			before = data
+		}
+	}
+	if data, err := git(ctx, "cat-file", "blob", head+":"+p); err == nil {
+		after = data
+	}
+	return before, after
+}
+
+// stagedDiff returns the staged changes to send to the model, see rangeDiff.
+func stagedDiff(ctx context.Context) (string, error) {
+	return rangeDiff(ctx, staged)
+}
+
+// rangeDiff returns the changes in r to send to the model. Paths matched by
+// .commitgptignore or with the -commitgpt attribute are left out. Paths with
+// commitgpt=summary, or marked linguist-generated or linguist-vendored, are
+// collapsed to a line each. Manifests and lockfiles are replaced by a list of
+// the dependencies that changed. The changes to exported Go declarations are
+// listed after the diff.
+func rangeDiff(ctx context.Context, r revRange) (string, error) {
+	paths, err := rangePaths(ctx, r)
+	if err != nil {
+		return "", err
+	}
+	if len(paths) == 0 {
+		return "", nil
+	}
+	modes, err := pathModes(ctx, r, paths)
+	if err != nil {
+		return "", err
+	}
+
+	args := r.diff("--no-color", "--no-ext-diff", "--src-prefix=a/", "--dst-prefix=b/", "--", ":/")
+	var included, summarised, deps []string
+	for _, path := range paths {
+		switch modes[path] {
+		case pathInclude:
+			included = append(included, path)
+			continue
+		case pathSummary:
+			summarised = append(summarised, path)
+		case pathDeps:
+			deps = append(deps, path)
+		case pathExclude:
+		}
+		args = append(args, ":(top,literal,exclude)"+path)
+	}
+	diff, err := git(ctx, args...)
+	if err != nil {
+		return "", err
+	}
+	result := string(diff)
+	result += summariseGoAPI(ctx, r, included)
+	if len(deps) > 0 {
+		summary, err := summariseDeps(ctx, r, deps, len(deps) == len(paths))
+		if err != nil {
+			return "", err
+		}
+		result += summary
+	}
+	if len(summarised) > 0 {
+		summary, err := summariseDiff(ctx, r, summarised)
+		if err != nil {
+			return "", err
+		}
+		result += summary
+	}
+	return result, nil
+}
+
+// stagedPaths returns the paths with staged changes, relative to the top of
+// the work tree.
+func stagedPaths(ctx context.Context) ([]string, error) {
+	return rangePaths(ctx, staged)
+}
+
+// rangePaths returns the paths changed in r, relative to the top of the work
+// tree.
+func rangePaths(ctx context.Context, r revRange) ([]string, error) {
+	out, err := git(ctx, r.diff("--name-only", "--no-renames", "-z")...)
+	if err != nil {
+		return nil, err
+	}
+	return splitNUL(out), nil
+}
+
+// summariseDiff describes the changes in r to paths in a line each.
+func summariseDiff(ctx context.Context, r revRange, paths []string) (string, error) {
+	args := r.diff("--numstat", "--no-renames", "-z", "--")
+	for _, path := range paths {
+		args = append(args, ":(top,literal)"+path)
+	}
+	out, err := git(ctx, args...)
+	if err != nil {
+		return "", err
+	}
+	var summary strings.Builder
+	for _, line := range splitNUL(out) {
+		fields := strings.SplitN(line, "\t", 3)
+		if len(fields) != 3 {
+			continue
+		}
+		if fields[0] == "-" {
+			fmt.Fprintf(&summary, "Summarised %s: binary file changed (diff not shown)\n", fields[2])
+		} else {
+			fmt.Fprintf(&summary, "Summarised %s: %s lines added, %s removed (diff not shown)\n", fields[2], fields[0], fields[1])
+		}
+	}
+	return summary.String(), nil
+}
+
+// pathModes decides how to send each of paths. The commitgpt attribute takes
+// precedence over .commitgptignore, which takes precedence over the dependency
+// summaries and the linguist-generated and linguist-vendored attributes.
+func pathModes(ctx context.Context, r revRange, paths []string) (map[string]pathMode, error) {
+	modes := make(map[string]pathMode, len(paths))
+
+	ignored, err := ignoredPaths(ctx, r)
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range paths {
+		if ignored[path] {
+			modes[path] = pathExclude
+		}
+	}
+
+	// The paths are relative to the top of the work tree, as git diff gives
+	// them, so check-attr must run there too.
+	top, err := git(ctx, "rev-parse", "--show-toplevel")
+	if err != nil {
+		return nil, err
+	}
+	args := append([]string{"-C", string(bytes.TrimSpace(top)), "check-attr", "-z", "--cached", "commitgpt", "linguist-generated", "linguist-vendored", "--"}, paths...)
+	out, err := git(ctx, args...)
+	if err != nil {
+		return nil, err
+	}
+	fields := splitNUL(out)
+	attrs := map[string]map[string]string{}
+	for i := 0; i+2 < len(fields); i += 3 {
+		path, attr, value := fields[i], fields[i+1], fields[i+2]
+		if attrs[path] == nil {
+			attrs[path] = map[string]string{}
+		}
+		attrs[path][attr] = value
+	}
+	for path, attr := range attrs {
+		switch attr["commitgpt"] {
+		case "unset":
+			modes[path] = pathExclude
+		case "summary":
+			modes[path] = pathSummary
+		case "set":
+			modes[path] = pathInclude
+		case "unspecified":
+			switch {
+			case modes[path] != pathInclude:
+			case isDepFile(path):
+				modes[path] = pathDeps
+			case isSetAttr(attr["linguist-generated"]) || isSetAttr(attr["linguist-vendored"]):
+				modes[path] = pathSummary
+			}
+		}
+	}
+	return modes, nil
+}
+
+// isSetAttr reports whether an attribute is set, as linguist does for
+// "linguist-generated" and "linguist-generated=true".
+func isSetAttr(value string) bool {
+	return value == "set" || value == "true"
+}
+
+// ignoredPaths returns the staged paths, including those deleted since HEAD or
+// the base of r, that match .commitgptignore at the top of the work tree.
+func ignoredPaths(ctx context.Context, r revRange) (map[string]bool, error) {
+	top, err := git(ctx, "rev-parse", "--show-toplevel")
+	if err != nil {
+		return nil, err
+	}
+	file := filepath.Join(string(bytes.TrimSpace(top)), ignoreFile)
+	if _, err := os.Stat(file); os.IsNotExist(err) {
+		return nil, nil
+	}
+	args := []string{"ls-files", "-z", "--cached", "--ignored", "--full-name", "--exclude-from=" + file}
+	if r.Base != "" {
+		args = append(args, "--with-tree="+r.Base)
+	} else if _, err := git(ctx, "rev-parse", "--verify", "--quiet", "HEAD"); err == nil {
+		args = append(args, "--with-tree=HEAD")
+	}
+	out, err := git(ctx, append([]string{"-C", string(bytes.TrimSpace(top))}, args...)...)
+	if err != nil {
+		return nil, err
+	}
+	ignored := map[string]bool{}
+	for _, path := range splitNUL(out) {
+		ignored[path] = true
+	}
+	return ignored, nil
+}
+
+func splitNUL(b []byte) []string {
+	s := strings.TrimSuffix(string(b), "\x00")
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, "\x00")
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// stageFiles writes files into the current repository and stages them.
+func stageFiles(t *testing.T, files map[string]string) {
+	for path, content := range files {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if out, err := exec.Command("git", "add", "-A").CombinedOutput(); err != nil {
+		t.Fatalf("git add: %v: %s", err, out)
+	}
+}
+
+// commitStaged commits the staged changes of the current repository.
+func commitStaged(t *testing.T, message string) {
+	commit := exec.Command("git", "-c", "user.name=a", "-c", "user.email=a@example.com", "commit", "-q", "-m", message)
+	if out, err := commit.CombinedOutput(); err != nil {
+		t.Fatalf("git commit: %v: %s", err, out)
+	}
+}
+
+func Test_pathModes(t *testing.T) {
+	chdirTempRepo(t)
+	stageFiles(t, map[string]string{
+		".commitgptignore": "vendor/\n*.snap\n!keep.snap\n",
+		".gitattributes": "*.pb.go linguist-generated\n" +
+			"third_party/** linguist-vendored=true\n" +
+			"go.sum commitgpt=summary\n" +
+			"secret.txt -commitgpt\n" +
+			"vendor/patched.go commitgpt\n",
+		"main.go":           "package main\n",
+		"api.pb.go":         "package main\n",
+		"third_party/lib.c": "int x;\n",
+		"go.sum":            "example.com/x v1.0.0 h1:abc=\n",
+		"secret.txt":        "x\n",
+		"vendor/lib.go":     "package lib\n",
+		"vendor/patched.go": "package lib\n",
+		"ui/button.snap":    "snapshot\n",
+		"ui/keep.snap":      "snapshot\n",
+	})
+	paths := []string{"main.go", "api.pb.go", "third_party/lib.c", "go.sum", "secret.txt", "vendor/lib.go", "vendor/patched.go", "ui/button.snap", "ui/keep.snap"}
+
+	want := map[string]pathMode{
+		"main.go":           pathInclude,
+		"api.pb.go":         pathSummary,
+		"third_party/lib.c": pathSummary,
+		"go.sum":            pathSummary,
+		"secret.txt":        pathExclude,
+		"vendor/lib.go":     pathExclude,
+		"vendor/patched.go": pathInclude,
+		"ui/button.snap":    pathExclude,
+		"ui/keep.snap":      pathInclude,
+	}
+	// The paths are relative to the top of the work tree, wherever git
+	// commit is run.
+	for _, dir := range []string{".", "ui"} {
+		t.Run(dir, func(t *testing.T) {
+			if err := os.Chdir(dir); err != nil {
+				t.Fatal(err)
+			}
+			modes, err := pathModes(context.Background(), staged, paths)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := map[string]pathMode{}
+			for _, path := range paths {
+				got[path] = modes[path]
+			}
+			if diff := cmp.Diff(want, got); diff != "" {
+				t.Errorf("pathModes() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func Test_stagedDiff(t *testing.T) {
+	chdirTempRepo(t)
+	stageFiles(t, map[string]string{
+		"main.go":   "package main\n",
+		"vendor/x":  "old\n",
+		"gen.pb.go": "package main\n",
+	})
+	commitStaged(t, "init")
+	os.Remove("vendor/x")
+	stageFiles(t, map[string]string{
+		".commitgptignore": "vendor/\n",
+		".gitattributes":   "*.pb.go linguist-generated\n",
+		"main.go":          "package main\n\nfunc main() {}\n",
+		"gen.pb.go":        "package main\n\nvar a, b = 1, 2\n",
+	})
+
+	got, err := stagedDiff(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"+func main() {}", "Summarised gen.pb.go: 2 lines added, 0 removed (diff not shown)\n", "diff --git a/.gitattributes"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("stagedDiff() missing %q:\n%s", want, got)
+		}
+	}
+	for _, unwanted := range []string{"vendor/x", "var a, b"} {
+		if strings.Contains(got, unwanted) {
+			t.Errorf("stagedDiff() contains %q:\n%s", unwanted, got)
+		}
+	}
+}
+
+func Test_stagedDiff_diffConfig(t *testing.T) {
+	chdirTempRepo(t)
+	for _, kv := range [][]string{{"diff.noprefix", "true"}, {"diff.mnemonicPrefix", "true"}, {"color.diff", "always"}} {
+		if out, err := exec.Command("git", "config", kv[0], kv[1]).CombinedOutput(); err != nil {
+			t.Fatalf("git config: %v: %s", err, out)
+		}
+	}
+	stageFiles(t, map[string]string{"main.go": "package main\n"})
+
+	got, err := stagedDiff(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "diff --git a/main.go b/main.go\n"; !strings.HasPrefix(got, want) {
+		t.Errorf("stagedDiff() = %q, want prefix %q", got, want)
+	}
+	if strings.Contains(got, "\x1b[") {
+		t.Errorf("stagedDiff() contains colour codes: %q", got)
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// severity is how serious a finding is.
+type severity int
+
+const (
+	severityInfo severity = iota
+	severityWarning
+	severityError
+)
+
+func (s severity) String() string {
+	switch s {
+	case severityWarning:
+		return "warning"
+	case severityError:
+		return "error"
+	}
+	return "info"
+}
+
+func parseSeverity(s string) (severity, error) {
+	switch strings.ToLower(s) {
+	case "info":
+		return severityInfo, nil
+	case "warning":
+		return severityWarning, nil
+	case "error":
+		return severityError, nil
+	}
+	return 0, fmt.Errorf("unknown severity %q: want info, warning or error", s)
+}
+
+// MarshalText writes the severity by name, in JSON.
+func (s severity) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// finding is a problem with a commit message. Line is the line of the
+// message it is on, from 1, or 0 for the message as a whole.
+type finding struct {
+	Rule     string   `json:"rule"`
+	Severity severity `json:"severity"`
+	Line     int      `json:"line,omitempty"`
+	Message  string   `json:"message"`
+}
+
+// conventionalTypes are the types of the Conventional Commits specification,
+// its common extensions, and the types that have a changelog section.
+var conventionalTypes = map[string]bool{
+	"build": true, "chore": true, "ci": true, "deps": true, "docs": true, "feat": true, "fix": true,
+	"perf": true, "refactor": true, "revert": true, "style": true, "test": true,
+	"deprecate": true, "remove": true, "security": true,
+}
+
+// maxSubjectLength is the longest subject line that passes the linter.
+const maxSubjectLength = 72
+
+// maxBodyLineLength is the longest line in the body that passes the linter,
+// unless it has no spaces, such as a URL.
+const maxBodyLineLength = 100
+
+var wipPattern = regexp.MustCompile(`(?i)^(wip\b|fixup!|squash!|amend!|tmp\b|temp\b)`)
+
+// lintMessage checks a commit message against the rules that commitgpt
+// follows when it writes one.
+func lintMessage(message string) []finding {
+	message = strings.TrimRight(message, "\n")
+	lines := strings.Split(message, "\n")
+	subject := lines[0]
+	if strings.TrimSpace(subject) == "" {
+		return []finding{{Rule: "subject-empty", Severity: severityError, Line: 1, Message: "the subject line is empty"}}
+	}
+
+	var findings []finding
+	add := func(rule string, sev severity, line int, format string, args ...interface{}) {
+		findings = append(findings, finding{Rule: rule, Severity: sev, Line: line, Message: fmt.Sprintf(format, args...)})
+	}
+	if wipPattern.MatchString(subject) {
+		add("subject-wip", severityError, 1, "the commit is a work in progress or fixup: %q", subject)
+	}
+	c, ok := parseConventional(message)
+	switch {
+	case !ok:
+		add("conventional", severityError, 1, "the subject line is not a conventional commit, such as \"fix(scope): description\"")
+	case !conventionalTypes[c.Type]:
+		add("type-unknown", severityWarning, 1, "the type %q is not a conventional type", c.Type)
+	}
+	if n := len([]rune(subject)); n > maxSubjectLength {
+		add("subject-length", severityWarning, 1, "the subject line is %d characters, more than %d", n, maxSubjectLength)
+	}
+	if strings.HasSuffix(strings.TrimSpace(subject), ".") {
+		add("subject-period", severityWarning, 1, "the subject line ends with a period")
+	}
+	if len(lines) > 1 && strings.TrimSpace(lines[1]) != "" {
+		add("body-blank-line", severityError, 2, "the subject line is not followed by a blank line")
+	}
+	for i, line := range lines[1:] {
+		if len([]rune(line)) > maxBodyLineLength && strings.Contains(strings.TrimSpace(line), " ") {
+			add("body-line-length", severityInfo, i+2, "the line is %d characters, more than %d", len([]rune(line)), maxBodyLineLength)
+		}
+	}
+	if ok && c.Breaking && c.BreakingNote == "" {
+		add("breaking-footer", severityInfo, 1, "the breaking change is not explained in a BREAKING CHANGE footer")
+	}
+	return findings
+}
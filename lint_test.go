@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func Test_lintMessage(t *testing.T) {
+	tests := []struct {
+		name    string
+		message string
+		want    []string // rule:severity:line
+	}{
+		{name: "good", message: "feat(api): add users\n\nThe users endpoint.\n"},
+		{name: "empty", message: "\n", want: []string{"subject-empty:error:1"}},
+		{name: "wip", message: "wip", want: []string{"subject-wip:error:1", "conventional:error:1"}},
+		{name: "fixup", message: "fixup! feat: a", want: []string{"subject-wip:error:1", "conventional:error:1"}},
+		{name: "unknown type", message: "feature: add users", want: []string{"type-unknown:warning:1"}},
+		{
+			name:    "long subject with a period",
+			message: "fix: " + strings.Repeat("a", 70) + ".",
+			want:    []string{"subject-length:warning:1", "subject-period:warning:1"},
+		},
+		{name: "no blank line", message: "fix: a\nbody", want: []string{"body-blank-line:error:2"}},
+		{
+			name:    "long body lines",
+			message: "fix: a\n\n" + strings.Repeat("word ", 25) + "\nhttps://example.com/" + strings.Repeat("x", 100),
+			want:    []string{"body-line-length:info:3"},
+		},
+		{name: "breaking without footer", message: "feat!: drop v1", want: []string{"breaking-footer:info:1"}},
+		{name: "breaking with footer", message: "feat!: drop v1\n\nBREAKING CHANGE: v1 is gone."},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, f := range lintMessage(tt.message) {
+				got = append(got, fmt.Sprintf("%s:%s:%d", f.Rule, f.Severity, f.Line))
+			}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("lintMessage() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
@@ -2,16 +2,21 @@ package main
 
 import (
 	"bytes"
+	"context"
 	_ "embed"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"os"
 	"os/exec"
+	"os/signal"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"syscall"
+	"time"
 )
 
 //go:embed prompt.txt
@@ -20,9 +25,23 @@ var promptData string
 var (
 	Endpoint         = "https://api.anthropic.com/v1/messages"
 	AnthropicVersion = "2023-06-01"
-	Model            = "claude-3-haiku-20240307"
 	MaxTokens        = 2048
 
+	// Timeout is the deadline for the whole hook, and for commitgpt
+	// pre-commit. Zero means no deadline.
+	Timeout = 2 * time.Minute
+
+	// Models are tried in order until one of them is available.
+	Models = []modelSpec{{Name: "claude-3-haiku-20240307"}}
+
+	// Providers are additional Messages API endpoints, by name, that models
+	// may be qualified with.
+	Providers = map[string]provider{}
+
+	// ThinkingBudget enables extended thinking, when the model supports it,
+	// with the given number of tokens. Zero disables extended thinking.
+	ThinkingBudget = 0
+
 	MillionInputTokensUnitPrice  = 0.25
 	MillionOutputTokensUnitPrice = 1.25
 )
@@ -91,71 +110,182 @@ func TransformText(r io.Reader) (tx io.Reader) {
 	return
 }
 
-func formatWarning(warning, content string) string {
-	cmd := exec.Command("pandoc", "--columns=70", "-t", "gfm")
+func formatWarning(ctx context.Context, warning, content string) (string, error) {
+	cmd := exec.CommandContext(ctx, "pandoc", "--columns=70", "-t", "gfm")
 	cmd.Stdin = TransformText(strings.NewReader(content))
 	var out bytes.Buffer
 	cmd.Stdout = &out
 	cmd.Stderr = os.Stderr
 	err := cmd.Run()
 	if err != nil {
-		fmt.Fprintln(os.Stderr, "pandoc:", err)
-		return ""
+		return "", fmt.Errorf("pandoc: %w", err)
 	}
-	formattedWarning := strings.ReplaceAll(out.String(), "\n", "\n# ")
-	return fmt.Sprintf("# **%s**\n# \n# %s\n", warning, formattedWarning)
+	c := CommentChar
+	formattedWarning := strings.ReplaceAll(out.String(), "\n", "\n"+c+" ")
+	return fmt.Sprintf("%s **%s**\n%s \n%s %s\n", c, warning, c, c, formattedWarning), nil
 }
 
-func formatPlain(content string) string {
-	cmd := exec.Command("pandoc", "--columns=72", "-t", "gfm")
+func formatPlain(ctx context.Context, content string) (string, error) {
+	cmd := exec.CommandContext(ctx, "pandoc", "--columns=72", "-t", "gfm")
 	cmd.Stdin = TransformText(strings.NewReader(content))
 	var out bytes.Buffer
 	cmd.Stdout = &out
+	cmd.Stderr = os.Stderr
 	err := cmd.Run()
 	if err != nil {
-		fmt.Println("Error running pandoc:", err)
-		return ""
+		return "", fmt.Errorf("pandoc: %w", err)
 	}
-	return fmt.Sprintf("%s\n", out.String())
+	return fmt.Sprintf("%s\n", out.String()), nil
 }
 
-func makeAPICall(branch, diff string) (_ string, err error) {
-	apiKey := os.Getenv("ANTHROPIC_API_KEY")
-	if apiKey == "" {
-		return
+func makeAPICall(ctx context.Context, branch, diff string) (string, error) {
+	gen, err := generate(ctx, branch, diff)
+	if err != nil {
+		return "", err
 	}
+	return renderResponse(ctx, gen)
+}
 
+// generation is the response of the first available model in the chain, and
+// why the models before it were skipped.
+type generation struct {
+	Response messageResponse
+	Model    modelSpec
+	Skipped  []string
+}
+
+// generate asks each model in turn for a commit message until one of them
+// answers. Sections are extra context for the prompt, see buildPrompt. The
+// diff and sections are redacted before they are sent.
+func generate(ctx context.Context, branch, diff string, sections ...string) (generation, error) {
 	branch = strings.TrimSpace(branch)
-	content := fmt.Sprintf(promptData, branch, diff)
+	prompt := redactPrompt(append([]string{diff}, sections...)...)
+	sections = append(append([]string(nil), prompt.Parts[1:]...), prompt.Note())
+	return prompt.ask(ctx, func(model modelSpec) (messageResponse, error) {
+		return sendMessage(ctx, model, branch, prompt.Parts[0], sections...)
+	})
+}
 
-	data := map[string]interface{}{
-		"model":      Model,
-		"max_tokens": MaxTokens,
-		"messages": []map[string]string{
-			{"role": "user", "content": content},
-		},
+// fallback calls send with each model in turn until one of them answers.
+func fallback(ctx context.Context, send func(modelSpec) (messageResponse, error)) (gen generation, err error) {
+	if len(Models) == 0 {
+		return gen, &stageError{Stage: stageConfig, Err: errors.New("no model is configured")}
+	}
+	var attempted bool
+	for _, gen.Model = range Models {
+		gen.Response, err = send(gen.Model)
+		if err == nil || ctx.Err() != nil || !shouldFallback(err) {
+			break
+		}
+		attempted = attempted || !errors.Is(err, errNoAPIKey)
+		gen.Skipped = append(gen.Skipped, fmt.Sprintf("%s (%v)", gen.Model, err))
+	}
+	if err != nil {
+		if errors.Is(err, errNoAPIKey) && !attempted {
+			return gen, &stageError{Stage: stageCredentials, Err: err}
+		}
+		return gen, &stageError{Stage: stageAPI, Err: err}
+	}
+	return gen, nil
+}
+
+// renderResponse formats the generated message, its warnings and a footer of
+// details for the commit message file, with the message template.
+func renderResponse(ctx context.Context, gen generation) (string, error) {
+	apiResponse, model := gen.Response, gen.Model
+	sensitiveWarn, largeFilesWarn, thought, commitMessage := extractMessages(apiResponse.Text())
+	if thinking := apiResponse.Thinking(); thinking != "" {
+		thought = thinking
+	}
+
+	var fields messageFields
+	fields.Raw.SensitiveWarning = sensitiveWarn
+	fields.Raw.LargeFilesWarning = largeFilesWarn
+	fields.Raw.Message = commitMessage
+	fields.Raw.Thought = thought
+	var err error
+	if sensitiveWarn != "" {
+		if fields.SensitiveWarning, err = formatWarning(ctx, "Sensitive Information Warning", sensitiveWarn); err != nil {
+			return "", &stageError{Stage: stageFormat, Err: err}
+		}
 	}
+	if largeFilesWarn != "" {
+		if fields.LargeFilesWarning, err = formatWarning(ctx, "Large Files Warning", largeFilesWarn); err != nil {
+			return "", &stageError{Stage: stageFormat, Err: err}
+		}
+	}
+	if commitMessage != "" {
+		if fields.Message, err = formatPlain(ctx, commitMessage); err != nil {
+			return "", &stageError{Stage: stageFormat, Err: err}
+		}
+	}
+	if thought != "" {
+		if fields.Thought, err = formatPlain(ctx, thought); err != nil {
+			return "", &stageError{Stage: stageFormat, Err: err}
+		}
+	}
+
+	fields.ID = apiResponse.Id
+	fields.Model = model.String()
+	if apiResponse.Model != "" && apiResponse.Model != model.Name {
+		fields.Model = fmt.Sprintf("%s (%s)", fields.Model, apiResponse.Model)
+	}
+	fields.Skipped = gen.Skipped
+	fields.StopReason = apiResponse.StopReason
+	fields.CommentChar = CommentChar
+	fields.Usage.InputTokens = apiResponse.Usage.InputTokens
+	fields.Usage.OutputTokens = apiResponse.Usage.OutputTokens
+	fields.InputCost = float64(apiResponse.Usage.InputTokens) * MillionInputTokensUnitPrice / 1e6
+	fields.OutputCost = float64(apiResponse.Usage.OutputTokens) * MillionOutputTokensUnitPrice / 1e6
+
+	response, err := executeMessageTemplate(fields)
+	if err != nil {
+		return "", &stageError{Stage: stageFormat, Err: err}
+	}
+	return strings.TrimSuffix(response, "\n"), nil
+}
+
+// postAPI sends data to url as JSON, authenticated for model, and decodes the
+// response into v. Error responses are returned as an *apiError.
+func postAPI(ctx context.Context, model modelSpec, apiKey, url string, data, v interface{}) error {
 	jsonData, err := json.Marshal(data)
 	if err != nil {
-		return
+		return err
 	}
 
-	req, err := http.NewRequest("POST", Endpoint, bytes.NewBuffer(jsonData))
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(jsonData))
 	if err != nil {
-		return
+		return err
 	}
-	req.Header.Set("x-api-key", apiKey)
-	req.Header.Set("anthropic-version", AnthropicVersion)
 	req.Header.Set("Content-Type", "application/json")
+	resp, err := doAPI(model, apiKey, req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// doAPI sends req, authenticated for model. Error responses are returned as an
+// *apiError, and otherwise the caller closes the response body.
+func doAPI(model modelSpec, apiKey string, req *http.Request) (*http.Response, error) {
+	setAPIKey(req.Header, model.apiKeyHeader(), apiKey)
+	req.Header.Set("anthropic-version", AnthropicVersion)
+	if err := setHeaders(req.Header, model.headers()); err != nil {
+		return nil, err
+	}
 
-	client := &http.Client{}
+	client, err := newHTTPClient()
+	if err != nil {
+		return nil, err
+	}
 	resp, err := client.Do(req)
 	if err != nil {
-		return
+		return nil, err
 	}
-	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
 		var errResponse struct {
 			Type  string `json:"type"`
 			Error struct {
@@ -164,69 +294,146 @@ func makeAPICall(branch, diff string) (_ string, err error) {
 			} `json:"error"`
 		}
 		json.NewDecoder(resp.Body).Decode(&errResponse)
-		if errResponse.Type == "" {
-			err = fmt.Errorf("unexpected status code: %d", resp.StatusCode)
-			return
+		return nil, &apiError{
+			StatusCode: resp.StatusCode,
+			Type:       errResponse.Error.Type,
+			Message:    errResponse.Error.Message,
 		}
-		err = fmt.Errorf("%s: %s: %s", errResponse.Type, errResponse.Error.Type, errResponse.Error.Message)
-		return
 	}
+	return resp, nil
+}
 
-	var apiResponse struct {
-		Id      string `json:"id"`
-		Content []struct {
-			Text string `json:"text"`
-		} `json:"content"`
-		StopReason string `json:"stop_reason"`
-		Usage      struct {
-			InputTokens  int `json:"input_tokens"`
-			OutputTokens int `json:"output_tokens"`
-		} `json:"usage"`
+// sendMessage asks model for a commit message and returns its response.
+func sendMessage(ctx context.Context, model modelSpec, branch, diff string, sections ...string) (messageResponse, error) {
+	thinking := ThinkingBudget > 0 && supportsThinking(model.Name)
+	return sendPrompt(ctx, model, buildPrompt(branch, diff, thinking, sections...), thinking)
+}
+
+// sendPrompt sends content to model, with extended thinking if thinking is
+// set, and returns its response.
+func sendPrompt(ctx context.Context, model modelSpec, content string, thinking bool) (apiResponse messageResponse, err error) {
+	apiKey, source, err := model.credentials().resolve(ctx)
+	if Verbose {
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "commitgpt: %s: %v\n", model, err)
+		} else {
+			fmt.Fprintf(os.Stderr, "commitgpt: %s: API key from %s\n", model, source)
+		}
 	}
-	err = json.NewDecoder(resp.Body).Decode(&apiResponse)
 	if err != nil {
 		return
 	}
 
-	if apiResponse.StopReason != "end_turn" {
-		err = fmt.Errorf("unexpected stop reason: %s", apiResponse.StopReason)
+	err = postAPI(ctx, model, apiKey, model.endpoint(), messageRequest(model, content, thinking), &apiResponse)
+	if err != nil {
 		return
 	}
+	err = checkResponse(apiResponse)
+	return
+}
 
-	if len(apiResponse.Content) == 0 || apiResponse.Content[0].Text == "" {
-		err = fmt.Errorf("no response from model")
-		return
+// messageRequest is the body of a Messages API request that sends content to
+// model.
+func messageRequest(model modelSpec, content string, thinking bool) map[string]interface{} {
+	data := map[string]interface{}{
+		"model":      model.Name,
+		"max_tokens": MaxTokens,
+		"messages": []map[string]string{
+			{"role": "user", "content": content},
+		},
 	}
-
-	sensitiveWarn, largeFilesWarn, thought, commitMessage := extractMessages(apiResponse.Content[0].Text)
-
-	var response strings.Builder
-	if sensitiveWarn != "" {
-		response.WriteString(formatWarning("Sensitive Information Warning", sensitiveWarn))
+	if thinking {
+		data["thinking"] = map[string]interface{}{
+			"type":          "enabled",
+			"budget_tokens": ThinkingBudget,
+		}
+		// The thinking budget counts towards max_tokens.
+		data["max_tokens"] = MaxTokens + ThinkingBudget
 	}
-	if largeFilesWarn != "" {
-		response.WriteString(formatWarning("Large Files Warning", largeFilesWarn))
+	return data
+}
+
+// checkResponse returns an error unless the model finished its answer.
+func checkResponse(apiResponse messageResponse) error {
+	if apiResponse.StopReason != "end_turn" {
+		return fmt.Errorf("unexpected stop reason: %s", apiResponse.StopReason)
 	}
-	if commitMessage != "" {
-		response.WriteString(formatPlain(commitMessage))
+	if apiResponse.Text() == "" {
+		return fmt.Errorf("no response from model")
 	}
+	return nil
+}
 
-	response.WriteString("# ------------------------ >8 ------------------------\n")
-	response.WriteString("# Do not modify or remove the line above.\n")
-	response.WriteString("# Everything below it will be ignored.\n")
-	response.WriteString("#\n")
-	response.WriteString(fmt.Sprintf("# API ID: %s\n", apiResponse.Id))
-	response.WriteString(fmt.Sprintf("# Input tokens: %d ($%.4f)\n", apiResponse.Usage.InputTokens, float64(apiResponse.Usage.InputTokens)*MillionInputTokensUnitPrice/1e6))
-	response.WriteString(fmt.Sprintf("# Output tokens: %d ($%.4f)\n", apiResponse.Usage.OutputTokens, float64(apiResponse.Usage.OutputTokens)*MillionOutputTokensUnitPrice/1e6))
-	response.WriteString("#\n")
+type contentBlock struct {
+	Type     string `json:"type"`
+	Text     string `json:"text"`
+	Thinking string `json:"thinking"`
+}
 
-	if thought != "" {
-		response.WriteString("# Below is the thought process that created the above message.\n")
-		response.WriteString(formatPlain(thought))
-		response.WriteString("\n")
+type messageResponse struct {
+	Id         string         `json:"id"`
+	Model      string         `json:"model"`
+	Content    []contentBlock `json:"content"`
+	StopReason string         `json:"stop_reason"`
+	Usage      struct {
+		InputTokens  int `json:"input_tokens"`
+		OutputTokens int `json:"output_tokens"`
+	} `json:"usage"`
+}
+
+// Text joins the text content blocks of the response.
+func (r messageResponse) Text() string {
+	var text strings.Builder
+	for _, block := range r.Content {
+		if block.Type == "text" || block.Type == "" {
+			text.WriteString(block.Text)
+		}
 	}
+	return text.String()
+}
 
-	return strings.TrimSuffix(response.String(), "\n"), nil
+// Thinking joins the thinking content blocks of the response. Redacted
+// thinking blocks are skipped.
+func (r messageResponse) Thinking() string {
+	var thinking []string
+	for _, block := range r.Content {
+		if block.Type == "thinking" && block.Thinking != "" {
+			thinking = append(thinking, strings.TrimSpace(block.Thinking))
+		}
+	}
+	return strings.Join(thinking, "\n\n")
+}
+
+// supportsThinking reports whether model accepts the extended thinking
+// parameter. Models prior to Claude 3.7 do not.
+func supportsThinking(model string) bool {
+	switch {
+	case strings.HasPrefix(model, "claude-3-7-"):
+		return true
+	case strings.HasPrefix(model, "claude-3-"),
+		strings.HasPrefix(model, "claude-2"),
+		strings.HasPrefix(model, "claude-instant"):
+		return false
+	}
+	return strings.HasPrefix(model, "claude-")
+}
+
+// buildPrompt fills the prompt template. Each of sections is a block of
+// context placed after the diff. With extended thinking the model reasons
+// before answering, so it is not asked for a <thinkthrough> section.
+func buildPrompt(branch, diff string, thinking bool, sections ...string) string {
+	prompt := promptData
+	if thinking {
+		prompt = strings.Replace(prompt, "In a <thinkthrough> section, analyse", "Before writing anything, analyse", 1)
+	}
+	var extra strings.Builder
+	for _, section := range sections {
+		if section = strings.TrimSpace(section); section != "" {
+			extra.WriteString(section)
+			extra.WriteString("\n")
+		}
+	}
+	return fmt.Sprintf(prompt, branch, diff, extra.String())
 }
 
 func extractMessages(apiResponse string) (string, string, string, string) {
@@ -269,24 +476,29 @@ func extractMessages(apiResponse string) (string, string, string, string) {
 		strings.TrimSuffix(commitMessage.String(), "\n")
 }
 
+// handleVerboseContent returns git's comments from a commit message file,
+// without the instructions that start them, and the diff of git commit -v,
+// without the scissors line. They are found by their structure rather than
+// their text, which depends on the language git uses.
 func handleVerboseContent(content string) string {
 	lines := strings.Split(content, "\n")
+	start, end := gitComments(lines, CommentChar)
 
-	var verboseContent strings.Builder
-	var i int
-	for i = 0; i < len(lines); i++ {
-		if strings.HasPrefix(lines[i], "# Please enter the commit message for your changes.") {
-			break
-		}
-	}
-	for i = i + 1; i < len(lines); i++ {
-		if lines[i] == "#" {
+	// The instructions are the first paragraph of the comments.
+	from := end
+	for i := start; i < end; i++ {
+		if lines[i] == CommentChar {
+			from = i + 1
 			break
 		}
 	}
-	for i = i + 1; i < len(lines); i++ {
-		if lines[i] == "# ------------------------ >8 ------------------------" {
-			i += 2
+	var verboseContent strings.Builder
+	for i := from; i < len(lines); i++ {
+		if i == end {
+			// The scissors line is followed by comments that explain it.
+			for i+1 < len(lines) && strings.HasPrefix(lines[i+1], CommentChar) {
+				i++
+			}
 			continue
 		}
 		verboseContent.WriteString(lines[i])
@@ -296,9 +508,129 @@ func handleVerboseContent(content string) string {
 	return strings.TrimSuffix(verboseContent.String(), "\n")
 }
 
+// gitComments finds the comments, starting with c, that git adds to the end of
+// a commit message file, or before the scissors line. They are
+// lines[start:end], and end is the scissors line, if any. start is end when
+// there are none.
+func gitComments(lines []string, c string) (start, end int) {
+	end = len(lines)
+	for i, line := range lines {
+		if line == c+" "+cutLine {
+			end = i
+			break
+		}
+	}
+	last := end
+	for last > 0 && lines[last-1] == "" {
+		last--
+	}
+	start = last
+	for start > 0 && strings.HasPrefix(lines[start-1], c) {
+		start--
+	}
+	return start, end
+}
+
+// annotateTemplate adds a comment line to the commit template, just above
+// git's own comments so that the blank first line is kept for the message.
+func annotateTemplate(content, note string) string {
+	c := commentCharFor(content)
+	lines := strings.SplitAfter(content, "\n")
+	i, end := gitComments(strings.Split(content, "\n"), c)
+	if i == end {
+		for i = 0; i < len(lines); i++ {
+			if strings.HasPrefix(lines[i], c) {
+				break
+			}
+		}
+	}
+	if i == len(lines) && !strings.HasSuffix(content, "\n") && content != "" {
+		lines[i-1] += "\n"
+	}
+	annotated := append([]string{}, lines[:i]...)
+	annotated = append(annotated, c+" "+note+"\n")
+	annotated = append(annotated, lines[i:]...)
+	return strings.Join(annotated, "")
+}
+
+// commands are the subcommands of commitgpt. They are given the arguments that
+// follow the command name and return the exit code.
+var commands = map[string]func(ctx context.Context, config gitConfig, args []string) int{
+	"changelog":  changelogCommand,
+	"check":      checkCommand,
+	"doctor":     doctorCommand,
+	"pr":         prCommand,
+	"pre-commit": preCommitCommand,
+	"reword":     rewordCommand,
+	"semver":     semverCommand,
+	"split":      splitCommand,
+}
+
+// loadConfig loads and applies the commitgpt settings, and the git settings
+// that decide how the commit message file is cleaned up.
+func loadConfig(ctx context.Context) (gitConfig, error) {
+	config, err := loadGitConfig(ctx)
+	if err == nil {
+		err = applyConfig(config)
+	}
+	if err == nil {
+		err = loadCommitConfig(ctx)
+	}
+	return config, err
+}
+
+// setup loads the configuration for command, or for the hook if command is
+// empty, and returns a context that is done on SIGINT/SIGTERM. Timeout only
+// applies to the hooks, as the other commands may make many requests or wait
+// for the user. The doctor loads the configuration itself, so that it can
+// report what is wrong with it.
+func setup(command string) (context.Context, gitConfig, context.CancelFunc, error) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	if command == "doctor" {
+		return ctx, nil, stop, nil
+	}
+	config, err := loadConfig(ctx)
+	if err != nil {
+		stop()
+		return nil, nil, nil, err
+	}
+	if Timeout <= 0 || (command != "" && command != "pre-commit") {
+		return ctx, config, stop, nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, Timeout)
+	return ctx, config, func() { cancel(); stop() }, nil
+}
+
 func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprintln(os.Stderr, "usage: commitgpt <commit-msg-file> [<source> [<sha>]]")
+		fmt.Fprintln(os.Stderr, "       commitgpt changelog [--summary] [--version <name>] [--prepend <file>] [<from>..<to>]")
+		fmt.Fprintln(os.Stderr, "       commitgpt check [--review] [--format <format>] [--fail-on <severity>] <base>..<head>")
+		fmt.Fprintln(os.Stderr, "       commitgpt doctor")
+		fmt.Fprintln(os.Stderr, "       commitgpt pre-commit [--sarif <file>]")
+		fmt.Fprintln(os.Stderr, "       commitgpt pr [--base <branch>] [--output <file>]")
+		fmt.Fprintln(os.Stderr, "       commitgpt reword [--yes] [--batch | --no-batch] <from>..HEAD")
+		fmt.Fprintln(os.Stderr, "       commitgpt semver [--tag | --no-tag] [<from>..<to>]")
+		fmt.Fprintln(os.Stderr, "       commitgpt semver --staged")
+		fmt.Fprintln(os.Stderr, "       commitgpt split [--abort]")
+		os.Exit(2)
+	}
+	if command, ok := commands[os.Args[1]]; ok {
+		ctx, config, cancel, err := setup(os.Args[1])
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		code := command(ctx, config, os.Args[2:])
+		cancel()
+		os.Exit(code)
+	}
+
 	commitMsgFile := os.Args[1]
-	commitSource := os.Args[2]
+	var commitSource string
+	if len(os.Args) > 2 {
+		commitSource = os.Args[2]
+	}
 
 	skip := os.Getenv("SKIP_PREPARE_COMMIT_MSG")
 	if v, err := strconv.ParseBool(skip); skip != "" && (err != nil || v) {
@@ -313,55 +645,135 @@ func main() {
 		os.Exit(0)
 	}
 
+	ctx, _, cancel, err := setup("")
+	if err != nil {
+		os.Exit(handleFailure(context.Background(), commitMsgFile, &stageError{Stage: stageConfig, Err: err}))
+	}
+	err = runHook(ctx, commitMsgFile)
+	code := handleFailure(ctx, commitMsgFile, err)
+	cancel()
+	os.Exit(code)
+}
+
+// git runs git with args and returns its output. The error includes what git
+// printed to stderr.
+func git(ctx context.Context, args ...string) ([]byte, error) {
+	out, err := exec.CommandContext(ctx, "git", args...).Output()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && len(exitErr.Stderr) > 0 {
+		err = fmt.Errorf("git %s: %s", args[0], bytes.TrimSpace(exitErr.Stderr))
+	}
+	return out, err
+}
+
+// promptSections gathers the context from the repository that is added to
+// the prompt, given the staged paths and their scopes. The branch history is
+// left out, with a warning, if it cannot be found.
+func promptSections(ctx context.Context, paths, scopes []string) ([]string, error) {
+	examples, err := styleExamples(ctx, paths)
+	if err != nil {
+		return nil, err
+	}
+	history, err := branchHistory(ctx)
+	if err != nil {
+		// The history is only context, so a branch without a merge base or a
+		// bad commitgpt.upstream does not stop the commit.
+		fmt.Fprintln(os.Stderr, "commitgpt: branch history:", err)
+	}
+	return []string{history, examples, scopeSection(scopes)}, nil
+}
+
+// runHook writes a generated commit message to commitMsgFile. Errors are
+// returned as a *stageError so that handleFailure can apply its policy.
+func runHook(ctx context.Context, commitMsgFile string) error {
 	content, err := os.ReadFile(commitMsgFile)
 	if err != nil {
-		fmt.Fprintln(os.Stderr, err)
-		os.Exit(1)
+		return &stageError{Stage: stageTemplate, Err: err}
 	}
+	CommentChar = commentCharFor(string(content))
 	trailer := handleVerboseContent(string(content))
 
-	branch, err := exec.Command("git", "rev-parse", "--abbrev-ref", "HEAD").Output()
+	branch, err := git(ctx, "rev-parse", "--abbrev-ref", "HEAD")
 	if err != nil {
-		fmt.Fprintln(os.Stderr, err)
-		os.Exit(1)
+		return &stageError{Stage: stageGit, Err: err}
 	}
-	diff, err := exec.Command("git", "diff", "--cached").Output()
+	diff, err := stagedDiff(ctx)
 	if err != nil {
-		fmt.Fprintln(os.Stderr, err)
-		os.Exit(1)
+		return &stageError{Stage: stageGit, Err: err}
 	}
-	apiResponse, err := makeAPICall(string(branch), string(diff))
+	paths, err := stagedPaths(ctx)
 	if err != nil {
-		fmt.Fprintln(os.Stderr, err)
-		os.Exit(1)
+		return &stageError{Stage: stageGit, Err: err}
+	}
+	var scopes []string
+	if ScopeMode != "off" {
+		if scopes, err = inferScopes(ctx, paths); err != nil {
+			return &stageError{Stage: stageGit, Err: err}
+		}
+	}
+	sections, err := promptSections(ctx, paths, scopes)
+	if err != nil {
+		return &stageError{Stage: stageGit, Err: err}
+	}
+	gen, err := generate(ctx, string(branch), diff, sections...)
+	if err != nil {
+		return err
+	}
+	if ScopeMode == "rewrite" {
+		gen.Response = rewriteResponseScope(gen.Response, scopes)
+	}
+	sensitiveWarn, largeFilesWarn, _, _ := extractMessages(gen.Response.Text())
+	if err = archiveSARIF(ctx, diff, sensitiveWarn, largeFilesWarn); err != nil {
+		return &stageError{Stage: stageLog, Err: err}
+	}
+	if BlockSensitive {
+		if err = checkSensitive(ctx, sensitiveWarn); err != nil {
+			return err
+		}
+	}
+	var apiResponse string
+	if cleanupKeepsComments() {
+		// git would keep the warnings and details in the commit, so only the
+		// message is written, above the file as git wrote it.
+		apiResponse, err = renderMessageOnly(ctx, gen)
+		trailer = strings.TrimLeft(string(content), "\n")
+	} else {
+		apiResponse, err = renderResponse(ctx, gen)
+	}
+	if err != nil {
+		return err
+	}
+	// A cancelled pandoc may leave a partial response.
+	if err = ctx.Err(); err != nil {
+		return err
 	}
 	if apiResponse == "" {
-		os.Exit(0)
+		return nil
+	}
+	if SplitAdvice && !cleanupKeepsComments() {
+		apiResponse += "\n" + splitAdviceComment(ctx)
 	}
 	err = os.WriteFile(commitMsgFile, []byte(apiResponse+"\n"+trailer), 0644)
 	if err != nil {
-		fmt.Fprintln(os.Stderr, err)
-		os.Exit(1)
+		return &stageError{Stage: stageWrite, Err: err}
 	}
 	logDir := os.Getenv("ANTHROPIC_LOG_DIR")
 	if logDir == "" {
-		os.Exit(0)
+		return nil
 	}
 	err = os.MkdirAll(logDir, 0755)
 	if err != nil {
-		fmt.Fprintln(os.Stderr, err)
-		os.Exit(0)
+		return &stageError{Stage: stageLog, Err: err}
 	}
-	treeHash, err := exec.Command("git", "write-tree").Output()
+	treeHash, err := git(ctx, "write-tree")
 	if err != nil {
-		fmt.Fprintln(os.Stderr, err)
-		os.Exit(0)
+		return &stageError{Stage: stageLog, Err: err}
 	}
 	treeHash = bytes.TrimSpace(treeHash)
 	logFile := filepath.Join(logDir, fmt.Sprintf("%s.log", treeHash))
 	err = os.WriteFile(logFile, []byte(apiResponse+"\n"+trailer), 0644)
 	if err != nil {
-		fmt.Fprintln(os.Stderr, err)
-		os.Exit(0)
+		return &stageError{Stage: stageLog, Err: err}
 	}
+	return nil
 }
@@ -1,13 +1,18 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/google/go-cmp/cmp"
 )
@@ -194,7 +199,10 @@ Please remove the sensitive information from the code and commit again.
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got := formatWarning(tt.args.title, tt.args.text)
+			got, err := formatWarning(context.Background(), tt.args.title, tt.args.text)
+			if err != nil {
+				t.Error(err)
+			}
 			if diff := cmp.Diff(tt.want, got); diff != "" {
 				t.Errorf("formatWarning() mismatch (-want +got):\n%s", diff)
 			}
@@ -233,7 +241,10 @@ AssertExpectedCalls behaviour for whatever reason.
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got := formatPlain(tt.args.text)
+			got, err := formatPlain(context.Background(), tt.args.text)
+			if err != nil {
+				t.Error(err)
+			}
 			if diff := cmp.Diff(tt.want, got); diff != "" {
 				t.Errorf("formatPlain() mismatch (-want +got):\n%s", diff)
 			}
@@ -304,6 +315,45 @@ index 4f6b7e7..b1b3b3e 100644
 	}
 }
 
+func Test_handleVerboseContent_locales(t *testing.T) {
+	chdirTempRepo(t)
+	stageFiles(t, map[string]string{"a.go": "package a\n"})
+	if err := os.WriteFile(".git/template.txt", []byte("# Summary\n\n# Why?\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	argss := [][]string{nil, {"-v"}, {"-t", ".git/template.txt"}, {"-v", "-t", ".git/template.txt"}}
+	for _, lang := range []string{"de", "fr", "ko", "es"} {
+		for _, args := range argss {
+			t.Run(strings.Join(append([]string{lang}, args...), " "), func(t *testing.T) {
+				english := gitTemplate(t, []string{"LC_ALL=C"}, args...)
+				translated := gitTemplate(t, []string{"LC_ALL=", "LANG=C.UTF-8", "LANGUAGE=" + lang}, args...)
+				if translated == english {
+					t.Skipf("git has no %s translation here", lang)
+				}
+				want, got := handleVerboseContent(english), handleVerboseContent(translated)
+
+				// The comments are translated, but there are as many, and the
+				// diff is the same.
+				wantLines, gotLines := strings.Split(want, "\n"), strings.Split(got, "\n")
+				if len(gotLines) != len(wantLines) {
+					t.Fatalf("handleVerboseContent() = %q, want the %d lines of %q", got, len(wantLines), want)
+				}
+				for i := range wantLines {
+					if strings.HasPrefix(wantLines[i], "#") != strings.HasPrefix(gotLines[i], "#") ||
+						!strings.HasPrefix(wantLines[i], "#") && gotLines[i] != wantLines[i] {
+						t.Errorf("line %d = %q, want %q", i+1, gotLines[i], wantLines[i])
+					}
+				}
+				// The instructions mention the comment character, and the
+				// message is left out.
+				if strings.Contains(got, "'#'") || strings.Contains(got, "Summary") || strings.Contains(got, cutLine) {
+					t.Errorf("handleVerboseContent() = %q, want only the status and diff", got)
+				}
+			})
+		}
+	}
+}
+
 func Test_makeAPICall(t *testing.T) {
 	tests := []struct {
 		name string
@@ -362,6 +412,7 @@ performance and resource utilization in various deployment scenarios.
 # Everything below it will be ignored.
 #
 # API ID: 12345
+# Model: claude-3-haiku-20240307
 # Input tokens: 10 ($0.0000)
 # Output tokens: 5 ($0.0000)
 #
@@ -447,7 +498,7 @@ the application’s flexibility and configurability.
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			defer newHTTPTestServer(t, tt.args)()
-			got, err := makeAPICall("main", tt.args)
+			got, err := makeAPICall(context.Background(), "main", tt.args)
 			if diff := cmp.Diff(tt.want, got); diff != "" {
 				t.Errorf("makeAPICall() mismatch (-want +got):\n%s", diff)
 			}
@@ -458,6 +509,16 @@ the application’s flexibility and configurability.
 	}
 }
 
+// fakePandoc puts a pandoc on PATH that copies its input, for tests that are
+// not about formatting.
+func fakePandoc(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "pandoc"), []byte("#!/bin/sh\ncat\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+}
+
 func newHTTPTestServer(t *testing.T, diff string) func() {
 	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if r.URL.Path != "/v1/messages" {
@@ -503,7 +564,7 @@ func newHTTPTestServer(t *testing.T, diff string) func() {
 		if !ok {
 			t.Errorf("unexpected content type: %T", message["content"])
 		}
-		if content != fmt.Sprintf(promptData, "main", diff) {
+		if content != fmt.Sprintf(promptData, "main", diff, "") {
 			t.Errorf("unexpected content: %s", content)
 		}
 		w.Header().Set("Content-Type", "application/json")
@@ -627,3 +688,207 @@ The provided diff does not contain any files larger than 50MB.
 	t.Setenv("ANTHROPIC_API_KEY", "test-api-key")
 	return ts.Close
 }
+
+func Test_supportsThinking(t *testing.T) {
+	tests := []struct {
+		model string
+		want  bool
+	}{
+		{"claude-3-haiku-20240307", false},
+		{"claude-3-5-sonnet-20241022", false},
+		{"claude-3-7-sonnet-20250219", true},
+		{"claude-sonnet-4-20250514", true},
+		{"claude-opus-4-1-20250805", true},
+		{"claude-2.1", false},
+		{"gpt-4o", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.model, func(t *testing.T) {
+			if got := supportsThinking(tt.model); got != tt.want {
+				t.Errorf("supportsThinking() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_messageResponse(t *testing.T) {
+	var resp messageResponse
+	err := json.Unmarshal([]byte(`{
+		"id": "msg_1",
+		"content": [
+			{"type": "thinking", "thinking": "First, look at the diff.\n", "signature": "abc"},
+			{"type": "redacted_thinking", "data": "xyz"},
+			{"type": "thinking", "thinking": "Then write the message."},
+			{"type": "text", "text": "<commit-message>\nfeat: "},
+			{"type": "text", "text": "add thing\n</commit-message>"}
+		],
+		"stop_reason": "end_turn"
+	}`), &resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff("<commit-message>\nfeat: add thing\n</commit-message>", resp.Text()); diff != "" {
+		t.Errorf("Text() mismatch (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff("First, look at the diff.\n\nThen write the message.", resp.Thinking()); diff != "" {
+		t.Errorf("Thinking() mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func Test_makeAPICall_thinking(t *testing.T) {
+	tests := []struct {
+		name      string
+		model     string
+		budget    int
+		wantThink bool
+	}{
+		{name: "disabled", model: "claude-sonnet-4-20250514", budget: 0},
+		{name: "unsupported model", model: "claude-3-haiku-20240307", budget: 2048},
+		{name: "enabled", model: "claude-sonnet-4-20250514", budget: 2048, wantThink: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var data map[string]interface{}
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+					t.Error(err)
+				}
+				w.Header().Set("Content-Type", "application/json")
+				io.WriteString(w, `{"id":"1","content":[{"type":"text","text":"<commit-message>\nfix: x\n</commit-message>"}],"stop_reason":"end_turn"}`)
+			}))
+			defer ts.Close()
+			defer func(endpoint string, models []modelSpec, budget int) {
+				Endpoint, Models, ThinkingBudget = endpoint, models, budget
+			}(Endpoint, Models, ThinkingBudget)
+			Endpoint, Models, ThinkingBudget = ts.URL, []modelSpec{{Name: tt.model}}, tt.budget
+			t.Setenv("ANTHROPIC_API_KEY", "test-api-key")
+			fakePandoc(t)
+
+			if _, err := makeAPICall(context.Background(), "main", "diff"); err != nil {
+				t.Fatal(err)
+			}
+			_, gotThink := data["thinking"]
+			if gotThink != tt.wantThink {
+				t.Errorf("thinking present = %v, want %v", gotThink, tt.wantThink)
+			}
+			content := data["messages"].([]interface{})[0].(map[string]interface{})["content"].(string)
+			if got := strings.Contains(content, "<thinkthrough>"); got == tt.wantThink {
+				t.Errorf("prompt mentions <thinkthrough> = %v, want %v", got, !tt.wantThink)
+			}
+			wantMax := MaxTokens
+			if tt.wantThink {
+				wantMax += tt.budget
+			}
+			if fmt.Sprint(data["max_tokens"]) != fmt.Sprint(wantMax) {
+				t.Errorf("max_tokens = %v, want %d", data["max_tokens"], wantMax)
+			}
+		})
+	}
+}
+
+func Test_buildPrompt_sections(t *testing.T) {
+	got := buildPrompt("main", "diff", false, "<a>\nfirst\n</a>\n", "", "<b>second</b>")
+	if !strings.Contains(got, "<branch>\nmain\n</branch>\n<diff>\ndiff\n</diff>\n") {
+		t.Errorf("buildPrompt() branch and diff not in their tags:\n%s", got)
+	}
+	if !strings.Contains(got, "</diff>\n<a>\nfirst\n</a>\n<b>second</b>\n\nPlease carefully review") {
+		t.Errorf("buildPrompt() sections not placed after the diff:\n%s", got)
+	}
+	if got := buildPrompt("main", "diff", false); got != fmt.Sprintf(promptData, "main", "diff", "") {
+		t.Errorf("buildPrompt() without sections = %s", got)
+	}
+}
+
+func Test_annotateTemplate(t *testing.T) {
+	tests := []struct {
+		name string
+		args string
+		want string
+	}{
+		{
+			name: "default template",
+			args: "\n# Please enter the commit message for your changes. Lines starting\n# with '#' will be ignored.\n",
+			want: "\n# commitgpt: note\n# Please enter the commit message for your changes. Lines starting\n# with '#' will be ignored.\n",
+		},
+		{
+			name: "custom template",
+			args: "feat: \n\nWhy?\n# Please enter the commit message\n",
+			want: "feat: \n\nWhy?\n# commitgpt: note\n# Please enter the commit message\n",
+		},
+		{
+			name: "template with comments",
+			args: "# Summary\n\n\n# Bitte geben Sie eine Commit-Beschreibung ein.\n#\n# Auf Branch main\n",
+			want: "# Summary\n\n\n# commitgpt: note\n# Bitte geben Sie eine Commit-Beschreibung ein.\n#\n# Auf Branch main\n",
+		},
+		{
+			name: "no comments",
+			args: "fix: something",
+			want: "fix: something\n# commitgpt: note\n",
+		},
+		{
+			name: "empty",
+			args: "",
+			want: "# commitgpt: note\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := annotateTemplate(tt.args, "commitgpt: note")
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("annotateTemplate() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func Test_makeAPICall_timeout(t *testing.T) {
+	done := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-done:
+		}
+	}))
+	defer ts.Close()
+	defer close(done)
+	defer func(endpoint string) { Endpoint = endpoint }(Endpoint)
+	Endpoint = ts.URL
+	t.Setenv("ANTHROPIC_API_KEY", "test-api-key")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	got, err := makeAPICall(ctx, "main", "diff")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("makeAPICall() err = %v, want %v", err, context.DeadlineExceeded)
+	}
+	if got != "" {
+		t.Errorf("makeAPICall() = %q, want empty", got)
+	}
+}
+
+func Test_setup_timeout(t *testing.T) {
+	chdirTempRepo(t)
+	resetFailurePolicies(t)
+	defer func(timeout time.Duration) { Timeout = timeout }(Timeout)
+	t.Setenv("COMMITGPT_TIMEOUT", "1m")
+
+	for command, want := range map[string]bool{
+		"":           true,
+		"pre-commit": true,
+		"reword":     false,
+		"check":      false,
+		"changelog":  false,
+		"pr":         false,
+	} {
+		t.Run(command, func(t *testing.T) {
+			ctx, _, cancel, err := setup(command)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer cancel()
+			if _, got := ctx.Deadline(); got != want {
+				t.Errorf("setup(%q) has a deadline: %v, want %v", command, got, want)
+			}
+		})
+	}
+}
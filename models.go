@@ -0,0 +1,147 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// modelSpec is an entry in the model fallback chain. Provider names a
+// [commitgpt "<provider>"] config section; the empty provider is the default
+// Anthropic endpoint.
+type modelSpec struct {
+	Provider string
+	Name     string
+}
+
+func (m modelSpec) String() string {
+	if m.Provider == "" {
+		return m.Name
+	}
+	return m.Provider + ":" + m.Name
+}
+
+// provider is an endpoint that speaks the Anthropic Messages API, such as an
+// API gateway or a second account.
+type provider struct {
+	Endpoint      string
+	APIKeyEnv     string
+	APIKeyFile    string
+	APIKeyHelper  string
+	CredentialURL string
+	APIKeyHeader  string
+	Headers       []string
+}
+
+// parseModelSpec splits "provider:model" when provider is configured, so that
+// model IDs which contain a colon are left alone.
+func parseModelSpec(s string) modelSpec {
+	s = strings.TrimSpace(s)
+	if name, model, ok := strings.Cut(s, ":"); ok {
+		if _, ok := Providers[name]; ok {
+			return modelSpec{Provider: name, Name: model}
+		}
+	}
+	return modelSpec{Name: s}
+}
+
+func (m modelSpec) endpoint() string {
+	if p, ok := Providers[m.Provider]; ok && p.Endpoint != "" {
+		return p.Endpoint
+	}
+	return Endpoint
+}
+
+// headers returns the extra headers for requests to m, after the global ones
+// so that a provider may override them.
+func (m modelSpec) headers() []string {
+	headers := Headers
+	if p, ok := Providers[m.Provider]; ok {
+		headers = append(headers[:len(headers):len(headers)], p.Headers...)
+	}
+	return headers
+}
+
+func (m modelSpec) apiKeyHeader() string {
+	return Providers[m.Provider].APIKeyHeader
+}
+
+var errNoAPIKey = errors.New("no API key")
+
+// apiError is an error response from the Messages API.
+type apiError struct {
+	StatusCode int
+	Type       string
+	Message    string
+}
+
+func (e *apiError) Error() string {
+	if e.Type == "" {
+		return fmt.Sprintf("unexpected status code: %d", e.StatusCode)
+	}
+	return fmt.Sprintf("error: %s: %s", e.Type, e.Message)
+}
+
+// shouldFallback reports whether err means the model is unavailable, in which
+// case the next model in the chain is tried. Errors in the request itself
+// would fail the same way on every model, so they do not fall back.
+func shouldFallback(err error) bool {
+	if errors.Is(err, errNoAPIKey) {
+		return true
+	}
+	var apiErr *apiError
+	if errors.As(err, &apiErr) {
+		switch apiErr.Type {
+		case "not_found_error", "overloaded_error", "rate_limit_error", "api_error",
+			"authentication_error", "permission_error":
+			return true
+		case "":
+			return apiErr.StatusCode == http.StatusNotFound ||
+				apiErr.StatusCode == http.StatusTooManyRequests ||
+				apiErr.StatusCode >= 500
+		}
+		return false
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+// loadProviders reads every [commitgpt "<name>"] section of the config, other
+// than [commitgpt "policy"].
+func loadProviders(c gitConfig) map[string]provider {
+	providers := map[string]provider{}
+	for key := range c {
+		rest := strings.TrimPrefix(key, "commitgpt.")
+		i := strings.LastIndex(rest, ".")
+		if i < 0 {
+			continue
+		}
+		section, name := rest[:i], rest[i+1:]
+		if section == "policy" {
+			continue
+		}
+		p := providers[section]
+		switch name {
+		case "endpoint":
+			p.Endpoint = c.String(key, "", "")
+		case "apikeyenv":
+			p.APIKeyEnv = c.String(key, "", "")
+		case "apikeyfile":
+			p.APIKeyFile = c.String(key, "", "")
+		case "apikeyhelper":
+			p.APIKeyHelper = c.String(key, "", "")
+		case "credentialurl":
+			p.CredentialURL = c.String(key, "", "")
+		case "apikeyheader":
+			p.APIKeyHeader = c.String(key, "", "")
+		case "header":
+			p.Headers = c.Strings(key, "", nil)
+		default:
+			continue
+		}
+		providers[section] = p
+	}
+	return providers
+}
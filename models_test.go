@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func Test_parseModelSpec(t *testing.T) {
+	defer func(providers map[string]provider) { Providers = providers }(Providers)
+	Providers = map[string]provider{"gateway": {Endpoint: "https://gateway.example.com/v1/messages"}}
+
+	tests := []struct {
+		args string
+		want modelSpec
+	}{
+		{"claude-3-haiku-20240307", modelSpec{Name: "claude-3-haiku-20240307"}},
+		{" gateway:claude-3-5-haiku-latest ", modelSpec{Provider: "gateway", Name: "claude-3-5-haiku-latest"}},
+		{"anthropic.claude-3-haiku-20240307-v1:0", modelSpec{Name: "anthropic.claude-3-haiku-20240307-v1:0"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.args, func(t *testing.T) {
+			if diff := cmp.Diff(tt.want, parseModelSpec(tt.args)); diff != "" {
+				t.Errorf("parseModelSpec() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func Test_loadProviders(t *testing.T) {
+	config := gitConfig{
+		"commitgpt.model":              {{Value: "Gateway:claude-x"}},
+		"commitgpt.Gateway.endpoint":   {{Value: "https://gateway.example.com/v1/messages"}},
+		"commitgpt.Gateway.apikeyenv":  {{Value: "GATEWAY_KEY"}},
+		"commitgpt.Gateway.unknownkey": {{Value: "ignored"}},
+	}
+	want := map[string]provider{
+		"Gateway": {Endpoint: "https://gateway.example.com/v1/messages", APIKeyEnv: "GATEWAY_KEY"},
+	}
+	if diff := cmp.Diff(want, loadProviders(config)); diff != "" {
+		t.Errorf("loadProviders() mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func Test_shouldFallback(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"no key", errNoAPIKey, true},
+		{"not found", &apiError{StatusCode: 404, Type: "not_found_error"}, true},
+		{"overloaded", &apiError{StatusCode: 529, Type: "overloaded_error"}, true},
+		{"rate limited", &apiError{StatusCode: 429, Type: "rate_limit_error"}, true},
+		{"invalid request", &apiError{StatusCode: 400, Type: "invalid_request_error"}, false},
+		{"bad gateway", &apiError{StatusCode: 502}, true},
+		{"bad request", &apiError{StatusCode: 400}, false},
+		{"wrapped", fmt.Errorf("gateway: %w", &apiError{StatusCode: 529, Type: "overloaded_error"}), true},
+		{"stop reason", errors.New("unexpected stop reason: max_tokens"), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := shouldFallback(tt.err); got != tt.want {
+				t.Errorf("shouldFallback() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_makeAPICall_fallback(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var data map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+			t.Error(err)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		switch data["model"] {
+		case "retired":
+			w.WriteHeader(http.StatusNotFound)
+			io.WriteString(w, `{"type":"error","error":{"type":"not_found_error","message":"model: retired"}}`)
+		case "busy":
+			w.WriteHeader(529)
+			io.WriteString(w, `{"type":"error","error":{"type":"overloaded_error","message":"Overloaded"}}`)
+		case "invalid":
+			w.WriteHeader(http.StatusBadRequest)
+			io.WriteString(w, `{"type":"error","error":{"type":"invalid_request_error","message":"prompt is too long"}}`)
+		default:
+			fmt.Fprintf(w, `{"id":"1","model":%q,"content":[{"type":"text","text":"<commit-message>\nfix: x\n</commit-message>"}],"stop_reason":"end_turn"}`, data["model"])
+		}
+	}))
+	defer ts.Close()
+	defer func(endpoint string, models []modelSpec, providers map[string]provider) {
+		Endpoint, Models, Providers = endpoint, models, providers
+	}(Endpoint, Models, Providers)
+	Endpoint = ts.URL
+	Providers = map[string]provider{"other": {APIKeyEnv: "OTHER_API_KEY"}}
+	t.Setenv("ANTHROPIC_API_KEY", "test-api-key")
+	t.Setenv("OTHER_API_KEY", "")
+	fakePandoc(t)
+
+	tests := []struct {
+		name   string
+		models []modelSpec
+		want   []string
+		err    string
+	}{
+		{
+			name:   "first available",
+			models: []modelSpec{{Name: "good"}, {Name: "busy"}},
+			want:   []string{"# Model: good\n# Input tokens"},
+		},
+		{
+			name:   "falls back",
+			models: []modelSpec{{Name: "retired"}, {Provider: "other", Name: "good"}, {Name: "busy"}, {Name: "good"}},
+			want: []string{
+				"# Model: good\n",
+				"# Skipped: retired (error: not_found_error: model: retired)\n",
+				"# Skipped: other:good (no API key (environment variable OTHER_API_KEY: empty))\n",
+				"# Skipped: busy (error: overloaded_error: Overloaded)\n",
+			},
+		},
+		{
+			name:   "request error stops",
+			models: []modelSpec{{Name: "invalid"}, {Name: "good"}},
+			err:    "error: invalid_request_error: prompt is too long",
+		},
+		{
+			name:   "all unavailable",
+			models: []modelSpec{{Name: "retired"}, {Name: "busy"}},
+			err:    "error: overloaded_error: Overloaded",
+		},
+		{
+			name:   "no API key",
+			models: []modelSpec{{Provider: "other", Name: "good"}},
+			err:    "no API key (environment variable OTHER_API_KEY: empty)",
+		},
+		{
+			name: "no models",
+			err:  "no model is configured",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			Models = tt.models
+			got, err := makeAPICall(context.Background(), "main", "diff")
+			if (err != nil || tt.err != "") && fmt.Sprint(err) != tt.err {
+				t.Errorf("makeAPICall() err = %v, want %v", err, tt.err)
+			}
+			if len(tt.want) == 0 && got != "" {
+				t.Errorf("makeAPICall() = %q, want empty", got)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(got, want) {
+					t.Errorf("makeAPICall() = %q, want to contain %q", got, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	_ "embed"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+//go:embed pr-prompt.txt
+var prPromptData string
+
+// prCommitLimit is the most commits on the branch that are shown to the model.
+const prCommitLimit = 100
+
+// pullRequest is a generated pull request description.
+type pullRequest struct {
+	Title string
+	Body  string
+}
+
+// String returns the title, a blank line and the body, like a commit message.
+func (pr pullRequest) String() string {
+	return pr.Title + "\n\n" + pr.Body + "\n"
+}
+
+// prCommand writes a title and description for a pull request of the current
+// branch to stdout, or the file given with --output.
+func prCommand(ctx context.Context, config gitConfig, args []string) int {
+	flags := flag.NewFlagSet("commitgpt pr", flag.ContinueOnError)
+	base := flags.String("base", Upstream, "the `branch` that the pull request merges into")
+	output := flags.String("output", "", "write the description to `file` instead of stdout")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() > 0 {
+		fmt.Fprintf(os.Stderr, "commitgpt pr: unexpected argument %q\n", flags.Arg(0))
+		return 2
+	}
+
+	pr, err := generatePR(ctx, *base)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "commitgpt pr:", err)
+		return 1
+	}
+	var w io.Writer = os.Stdout
+	if *output != "" && *output != "-" {
+		file, err := os.Create(*output)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "commitgpt pr:", err)
+			return 1
+		}
+		defer file.Close()
+		w = file
+	}
+	if _, err := io.WriteString(w, pr.String()); err != nil {
+		fmt.Fprintln(os.Stderr, "commitgpt pr:", err)
+		return 1
+	}
+	return 0
+}
+
+// generatePR asks each model in turn to describe the commits between the
+// merge base of HEAD and base, and HEAD. When base is empty, the upstream of
+// the current branch is used.
+func generatePR(ctx context.Context, base string) (pullRequest, error) {
+	if base == "" {
+		if _, err := git(ctx, "rev-parse", "--verify", "--quiet", "@{upstream}"); err != nil {
+			return pullRequest{}, errors.New("the branch has no upstream; give the base branch with --base")
+		}
+		base = "@{upstream}"
+	}
+	mergeBase, err := git(ctx, "merge-base", "HEAD", base)
+	if err != nil {
+		return pullRequest{}, fmt.Errorf("merge base of HEAD and %s: %w", base, err)
+	}
+	r := revRange{Base: strings.TrimSpace(string(mergeBase)), Head: "HEAD"}
+	commits, err := prCommits(ctx, r)
+	if err != nil {
+		return pullRequest{}, err
+	}
+	if commits == "" {
+		return pullRequest{}, fmt.Errorf("there are no commits on the branch since %s", base)
+	}
+	diff, err := rangeDiff(ctx, r)
+	if err != nil {
+		return pullRequest{}, err
+	}
+	branch, err := git(ctx, "rev-parse", "--abbrev-ref", "HEAD")
+	if err != nil {
+		return pullRequest{}, err
+	}
+
+	prompt := redactPrompt(commits, diff)
+	content := fmt.Sprintf(prPromptData, strings.TrimSpace(string(branch)), base, prompt.Parts[0], prompt.Parts[1], prompt.Note())
+	gen, err := prompt.ask(ctx, func(model modelSpec) (messageResponse, error) {
+		thinking := ThinkingBudget > 0 && supportsThinking(model.Name)
+		return sendPrompt(ctx, model, content, thinking)
+	})
+	if err != nil {
+		return pullRequest{}, err
+	}
+	pr, ok := parsePR(gen.Response.Text())
+	if !ok {
+		return pullRequest{}, &stageError{Stage: stageAPI, Err: errors.New("the response has no <pr-title> section")}
+	}
+	return pr, nil
+}
+
+// prCommits lists the commits in r, oldest first, with their messages.
+func prCommits(ctx context.Context, r revRange) (string, error) {
+	out, err := git(ctx, "log", "-z", "--reverse", "--no-merges", fmt.Sprintf("-n%d", prCommitLimit),
+		"--format=%s%x1f%b", r.Base+".."+r.Head)
+	if err != nil {
+		return "", err
+	}
+	var commits strings.Builder
+	for _, commit := range splitNUL(out) {
+		subject, body, _ := strings.Cut(commit, "\x1f")
+		fmt.Fprintf(&commits, "- %s\n", strings.TrimSpace(subject))
+		if body = strings.TrimSpace(body); body != "" {
+			fmt.Fprintf(&commits, "  %s\n", strings.ReplaceAll(body, "\n", "\n  "))
+		}
+	}
+	return strings.TrimSuffix(commits.String(), "\n"), nil
+}
+
+// parsePR reads the <pr-title> and <pr-body> sections of the model's answer.
+func parsePR(text string) (pullRequest, bool) {
+	title, ok := tagContent(text, "pr-title")
+	if !ok || title == "" {
+		return pullRequest{}, false
+	}
+	// The title is one line, even if the model wrapped it.
+	title = strings.Join(strings.Fields(title), " ")
+	body, _ := tagContent(text, "pr-body")
+	return pullRequest{Title: title, Body: body}, true
+}
+
+// tagContent returns the trimmed text between <tag> and </tag>.
+func tagContent(text, tag string) (string, bool) {
+	_, rest, ok := strings.Cut(text, "<"+tag+">")
+	if !ok {
+		return "", false
+	}
+	content, _, ok := strings.Cut(rest, "</"+tag+">")
+	return strings.TrimSpace(content), ok
+}
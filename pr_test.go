@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"os/exec"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func Test_parsePR(t *testing.T) {
+	tests := []struct {
+		name   string
+		text   string
+		want   pullRequest
+		wantOK bool
+	}{
+		{
+			name:   "title and body",
+			text:   "<pr-title>\nfeat: add retries\n</pr-title>\n<pr-body>\n## Summary\nRetries.\n</pr-body>\n",
+			want:   pullRequest{Title: "feat: add retries", Body: "## Summary\nRetries."},
+			wantOK: true,
+		},
+		{
+			name:   "wrapped title",
+			text:   "<pr-title>feat: add\n  retries</pr-title>",
+			want:   pullRequest{Title: "feat: add retries"},
+			wantOK: true,
+		},
+		{name: "no title", text: "<pr-body>\nx\n</pr-body>"},
+		{name: "empty title", text: "<pr-title></pr-title>"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := parsePR(tt.text)
+			if ok != tt.wantOK {
+				t.Errorf("parsePR() ok = %v, want %v", ok, tt.wantOK)
+			}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("parsePR() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func Test_generatePR(t *testing.T) {
+	prompt := fakeAPI(t, "<pr-title>feat: add b and c</pr-title>\n<pr-body>\n## Summary\nAdds b and c.\n</pr-body>")
+	ctx := context.Background()
+	chdirTempRepo(t)
+	exec.Command("git", "checkout", "-q", "-b", "main").Run()
+	stageFiles(t, map[string]string{"a.go": "package a\n", ".commitgptignore": "secret.txt\n"})
+	commitStaged(t, "feat: start")
+	exec.Command("git", "checkout", "-q", "-b", "feature").Run()
+	stageFiles(t, map[string]string{"b.go": "package a\n\nfunc B() {}\n", "secret.txt": "hunter2\n"})
+	commitStaged(t, "feat(b): add b\n\nIt is like a.")
+	stageFiles(t, map[string]string{"c.go": "package a\n"})
+	commitStaged(t, "feat(b): add c")
+
+	if _, err := generatePR(ctx, ""); err == nil || !strings.Contains(err.Error(), "--base") {
+		t.Errorf("generatePR() err = %v, want a hint to use --base", err)
+	}
+	if _, err := generatePR(ctx, "feature"); err == nil || !strings.Contains(err.Error(), "no commits") {
+		t.Errorf("generatePR() err = %v, want no commits", err)
+	}
+
+	got, err := generatePR(ctx, "main")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := pullRequest{Title: "feat: add b and c", Body: "## Summary\nAdds b and c."}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("generatePR() mismatch (-want +got):\n%s", diff)
+	}
+	if s := got.String(); s != "feat: add b and c\n\n## Summary\nAdds b and c.\n" {
+		t.Errorf("String() = %q", s)
+	}
+	for _, want := range []string{
+		"<branch>feature</branch>",
+		"<base>main</base>",
+		"- feat(b): add b\n  It is like a.\n- feat(b): add c\n",
+		"+func B() {}",
+		"Go API changes in . (package a):\n- added func B()",
+	} {
+		if !strings.Contains(*prompt, want) {
+			t.Errorf("prompt missing %q:\n%s", want, *prompt)
+		}
+	}
+	if strings.Contains(*prompt, "hunter2") || strings.Contains(*prompt, "feat: start") {
+		t.Errorf("prompt contains ignored changes or commits on main:\n%s", *prompt)
+	}
+	if strings.Contains(*prompt, "REDACTED-") {
+		t.Errorf("prompt explains placeholders when nothing was redacted:\n%s", *prompt)
+	}
+}
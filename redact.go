@@ -0,0 +1,272 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// redactor masks secrets and personal data in the diff before it is sent to
+// the model.
+type redactor struct {
+	// Patterns match secrets. When a pattern has a group, only the first
+	// group is masked. Secrets are never restored.
+	Patterns []*regexp.Regexp
+	// Hosts are internal domains, masked along with their subdomains.
+	Hosts []string
+	// Emails and IPs mask email addresses and IP addresses.
+	Emails bool
+	IPs    bool
+	// Restore puts masked emails, IP addresses and hosts back into the
+	// generated message.
+	Restore bool
+}
+
+// Redaction is configured with commitgpt.redact, commitgpt.redactHost,
+// commitgpt.redactEmails, commitgpt.redactIPs and commitgpt.redactRestore.
+var Redaction = redactor{Restore: true}
+
+// The kinds of masked values, used in their placeholders.
+const (
+	redactSecret = "SECRET"
+	redactEmail  = "EMAIL"
+	redactHost   = "HOST"
+	redactIP     = "IP"
+)
+
+var (
+	emailPattern = regexp.MustCompile(`[A-Za-z0-9_][A-Za-z0-9._%+-]*@[A-Za-z0-9-]+(?:\.[A-Za-z0-9-]+)*\.[A-Za-z]{2,}`)
+	ipv4Pattern  = regexp.MustCompile(`\b(?:(?:25[0-5]|2[0-4]\d|1\d\d|[1-9]?\d)\.){3}(?:25[0-5]|2[0-4]\d|1\d\d|[1-9]?\d)\b`)
+	ipv6Pattern  = regexp.MustCompile(`[0-9A-Fa-f]*:[0-9A-Fa-f:]*:[0-9A-Fa-f:.]*`)
+)
+
+func (r redactor) enabled() bool {
+	return len(r.Patterns) > 0 || len(r.Hosts) > 0 || r.Emails || r.IPs
+}
+
+// compileRedactPatterns compiles the regular expressions of commitgpt.redact.
+func compileRedactPatterns(exprs []string) ([]*regexp.Regexp, error) {
+	var patterns []*regexp.Regexp
+	for _, expr := range exprs {
+		if expr = strings.TrimSpace(expr); expr == "" {
+			continue
+		}
+		pattern, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("commitgpt.redact: %w", err)
+		}
+		patterns = append(patterns, pattern)
+	}
+	return patterns, nil
+}
+
+// redaction records the placeholders of one redacted diff. The same value is
+// always given the same placeholder.
+type redaction struct {
+	placeholders map[string]string // value to placeholder
+	values       map[string]string // placeholder to value
+	counts       map[string]int
+}
+
+func (d *redaction) placeholder(kind, value string) string {
+	if p, ok := d.placeholders[value]; ok {
+		return p
+	}
+	d.counts[kind]++
+	p := fmt.Sprintf("REDACTED-%s-%d", kind, d.counts[kind])
+	d.placeholders[value] = p
+	d.values[p] = value
+	return p
+}
+
+// Len is the number of distinct values that were masked.
+func (d *redaction) Len() int { return len(d.values) }
+
+// section returns a prompt section that explains the placeholders to the
+// model, or "" if nothing was redacted.
+func (d *redaction) section() string {
+	if d == nil || d.Len() == 0 {
+		return ""
+	}
+	return "<redacted>\nValues such as REDACTED-EMAIL-1, REDACTED-HOST-1, REDACTED-IP-1 or\n" +
+		"REDACTED-SECRET-1 were masked before the diff was sent to you. They are not\n" +
+		"sensitive information. If you mention one of them, copy the placeholder\n" +
+		"exactly.\n</redacted>\n"
+}
+
+func newRedaction() *redaction {
+	return &redaction{
+		placeholders: map[string]string{},
+		values:       map[string]string{},
+		counts:       map[string]int{},
+	}
+}
+
+// redact masks every match in diff, secrets first, recording the placeholders
+// in d.
+func (r redactor) redact(d *redaction, diff string) string {
+	for _, pattern := range r.Patterns {
+		diff = replaceSubmatch(pattern, diff, func(value string) string {
+			return d.placeholder(redactSecret, value)
+		})
+	}
+	if r.Emails {
+		diff = emailPattern.ReplaceAllStringFunc(diff, func(value string) string {
+			return d.placeholder(redactEmail, value)
+		})
+	}
+	if hosts := hostPattern(r.Hosts); hosts != nil {
+		diff = hosts.ReplaceAllStringFunc(diff, func(value string) string {
+			return d.placeholder(redactHost, value)
+		})
+	}
+	if r.IPs {
+		mask := func(value string) string {
+			if !isRedactableIP(value) {
+				return value
+			}
+			return d.placeholder(redactIP, value)
+		}
+		diff = ipv4Pattern.ReplaceAllStringFunc(diff, mask)
+		diff = ipv6Pattern.ReplaceAllStringFunc(diff, mask)
+	}
+	return diff
+}
+
+// replaceSubmatch replaces the first group of each match of pattern, or the
+// whole match when pattern has no groups.
+func replaceSubmatch(pattern *regexp.Regexp, s string, repl func(string) string) string {
+	if pattern.NumSubexp() == 0 {
+		return pattern.ReplaceAllStringFunc(s, repl)
+	}
+	var b strings.Builder
+	last := 0
+	for _, m := range pattern.FindAllStringSubmatchIndex(s, -1) {
+		if m[2] < 0 || m[2] == m[3] {
+			continue
+		}
+		b.WriteString(s[last:m[2]])
+		b.WriteString(repl(s[m[2]:m[3]]))
+		last = m[3]
+	}
+	b.WriteString(s[last:])
+	return b.String()
+}
+
+// hostPattern matches any of hosts and their subdomains, or is nil when there
+// are no hosts.
+func hostPattern(hosts []string) *regexp.Regexp {
+	var quoted []string
+	for _, host := range hosts {
+		if host = strings.Trim(strings.TrimSpace(host), "."); host != "" {
+			quoted = append(quoted, regexp.QuoteMeta(host))
+		}
+	}
+	if len(quoted) == 0 {
+		return nil
+	}
+	return regexp.MustCompile(`(?i)\b(?:[a-z0-9-]+\.)*(?:` + strings.Join(quoted, "|") + `)\b`)
+}
+
+// isRedactableIP reports whether s is an IP address worth masking. Loopback
+// and unspecified addresses are left alone, as are IPv6-like strings without
+// a digit, which are more likely to be code such as a::b.
+func isRedactableIP(s string) bool {
+	ip := net.ParseIP(s)
+	if ip == nil || ip.IsLoopback() || ip.IsUnspecified() {
+		return false
+	}
+	return strings.Contains(s, ".") || strings.ContainsAny(s, "0123456789")
+}
+
+// restore puts the masked values back into s. Secrets are never restored, and
+// nothing is restored unless Restore is set.
+func (r redactor) restore(d *redaction, s string) string {
+	if !r.Restore || d == nil || d.Len() == 0 {
+		return s
+	}
+	var pairs []string
+	// Longer placeholders first, so REDACTED-IP-1 does not replace part of
+	// REDACTED-IP-10.
+	for i := maxCount(d.counts); i > 0; i-- {
+		for _, kind := range []string{redactEmail, redactHost, redactIP} {
+			p := fmt.Sprintf("REDACTED-%s-%d", kind, i)
+			if value, ok := d.values[p]; ok {
+				pairs = append(pairs, p, value)
+			}
+		}
+	}
+	return strings.NewReplacer(pairs...).Replace(s)
+}
+
+func maxCount(counts map[string]int) (max int) {
+	for _, n := range counts {
+		if n > max {
+			max = n
+		}
+	}
+	return
+}
+
+// restoreResponse restores the masked values in the text and thinking of
+// a response.
+func (r redactor) restoreResponse(d *redaction, resp messageResponse) messageResponse {
+	content := make([]contentBlock, len(resp.Content))
+	for i, block := range resp.Content {
+		block.Text = r.restore(d, block.Text)
+		block.Thinking = r.restore(d, block.Thinking)
+		content[i] = block
+	}
+	resp.Content = content
+	return resp
+}
+
+// redactedPrompt is the parts of a prompt, such as the diff and the commit
+// message, as they are sent to the model.
+type redactedPrompt struct {
+	Parts    []string
+	redacted *redaction
+}
+
+// redactPrompt masks the values in each of parts, if redaction is enabled.
+// The same value is given the same placeholder in every part.
+func redactPrompt(parts ...string) redactedPrompt {
+	p := redactedPrompt{Parts: parts}
+	if !Redaction.enabled() {
+		return p
+	}
+	p.redacted = newRedaction()
+	p.Parts = make([]string, len(parts))
+	for i, part := range parts {
+		p.Parts[i] = Redaction.redact(p.redacted, part)
+	}
+	if Verbose {
+		fmt.Fprintf(os.Stderr, "commitgpt: redacted %d values from the prompt\n", p.redacted.Len())
+	}
+	return p
+}
+
+// Note is a prompt section that explains the placeholders to the model. It is
+// empty when nothing was redacted.
+func (p redactedPrompt) Note() string {
+	return p.redacted.section()
+}
+
+// restore puts the masked values back into resp.
+func (p redactedPrompt) restore(resp messageResponse) messageResponse {
+	return Redaction.restoreResponse(p.redacted, resp)
+}
+
+// ask sends the prompt with send to each model in turn, see fallback, and
+// puts the masked values back into the answer.
+func (p redactedPrompt) ask(ctx context.Context, send func(modelSpec) (messageResponse, error)) (generation, error) {
+	gen, err := fallback(ctx, send)
+	if err != nil {
+		return gen, err
+	}
+	gen.Response = p.restore(gen.Response)
+	return gen, nil
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func Test_redactor_redact(t *testing.T) {
+	tests := []struct {
+		name     string
+		redactor redactor
+		diff     string
+		want     string
+	}{
+		{
+			name:     "emails",
+			redactor: redactor{Emails: true},
+			diff:     "+owner: jane.doe@customer.com\n+cc: bob@example.org, jane.doe@customer.com\n-old+tag@customer.com\n",
+			want:     "+owner: REDACTED-EMAIL-1\n+cc: REDACTED-EMAIL-2, REDACTED-EMAIL-1\n-REDACTED-EMAIL-3\n",
+		},
+		{
+			name:     "hosts and subdomains",
+			redactor: redactor{Hosts: []string{"corp.internal", " ", "build.example.net"}},
+			diff:     "+url: https://db-1.eu.corp.internal:5432/app\n+ci: build.example.net\n+other: example.net\n",
+			want:     "+url: https://REDACTED-HOST-1:5432/app\n+ci: REDACTED-HOST-2\n+other: example.net\n",
+		},
+		{
+			name:     "IP addresses",
+			redactor: redactor{IPs: true},
+			diff:     "+addr: 10.1.2.3:8080\n+v6: fe80::1ff:fe23:4567:890a\n+local: 127.0.0.1, ::1, 0.0.0.0\n+code: std::vec, a::b, 12:30:45\n",
+			want:     "+addr: REDACTED-IP-1:8080\n+v6: REDACTED-IP-2\n+local: 127.0.0.1, ::1, 0.0.0.0\n+code: std::vec, a::b, 12:30:45\n",
+		},
+		{
+			name: "patterns",
+			redactor: redactor{Patterns: []*regexp.Regexp{
+				regexp.MustCompile(`sk-[A-Za-z0-9]{8,}`),
+				regexp.MustCompile(`password: (\S+)`),
+			}},
+			diff: "+key: sk-abcdefgh1234\n+password: hunter2\n",
+			want: "+key: REDACTED-SECRET-1\n+password: REDACTED-SECRET-2\n",
+		},
+		{
+			name:     "secrets before emails",
+			redactor: redactor{Emails: true, Patterns: []*regexp.Regexp{regexp.MustCompile(`smtp://\S+`)}},
+			diff:     "+url: smtp://user:pw@mail.example.com\n+to: ops@example.com\n",
+			want:     "+url: REDACTED-SECRET-1\n+to: REDACTED-EMAIL-1\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.redactor.redact(newRedaction(), tt.diff)
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("redact() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func Test_redactor_restore(t *testing.T) {
+	r := redactor{
+		Emails:   true,
+		IPs:      true,
+		Patterns: []*regexp.Regexp{regexp.MustCompile(`token=(\w+)`)},
+		Restore:  true,
+	}
+	var diff strings.Builder
+	for i := 1; i <= 10; i++ {
+		fmt.Fprintf(&diff, "+10.0.0.%d\n", i)
+	}
+	diff.WriteString("+jane@customer.com token=abc123\n")
+	d := newRedaction()
+	r.redact(d, diff.String())
+
+	message := "Move REDACTED-IP-1 and REDACTED-IP-10 for REDACTED-EMAIL-1, rotate REDACTED-SECRET-1"
+	want := "Move 10.0.0.1 and 10.0.0.10 for jane@customer.com, rotate REDACTED-SECRET-1"
+	if got := r.restore(d, message); got != want {
+		t.Errorf("restore() = %q, want %q", got, want)
+	}
+
+	r.Restore = false
+	if got := r.restore(d, message); got != message {
+		t.Errorf("restore() without Restore = %q, want %q", got, message)
+	}
+}
+
+func Test_compileRedactPatterns(t *testing.T) {
+	patterns, err := compileRedactPatterns([]string{`AKIA[0-9A-Z]{16}`, " ", `token=(\w+)`})
+	if err != nil || len(patterns) != 2 {
+		t.Errorf("compileRedactPatterns() = %v, %v, want 2 patterns", patterns, err)
+	}
+	if _, err := compileRedactPatterns([]string{`(`}); err == nil {
+		t.Error("compileRedactPatterns() expected error for invalid pattern")
+	}
+}
+
+func Test_redactPrompt(t *testing.T) {
+	defer func(redaction redactor) { Redaction = redaction }(Redaction)
+
+	Redaction = redactor{Restore: true}
+	p := redactPrompt("to jane@customer.com", "cc jane@customer.com")
+	if diff := cmp.Diff([]string{"to jane@customer.com", "cc jane@customer.com"}, p.Parts); diff != "" {
+		t.Errorf("redactPrompt() disabled mismatch (-want +got):\n%s", diff)
+	}
+	if p.Note() != "" {
+		t.Errorf("Note() = %q, want none when disabled", p.Note())
+	}
+
+	Redaction = redactor{Emails: true, Restore: true}
+	p = redactPrompt("to jane@customer.com", "cc jane@customer.com")
+	if diff := cmp.Diff([]string{"to REDACTED-EMAIL-1", "cc REDACTED-EMAIL-1"}, p.Parts); diff != "" {
+		t.Errorf("redactPrompt() mismatch (-want +got):\n%s", diff)
+	}
+	if !strings.HasPrefix(p.Note(), "<redacted>\n") {
+		t.Errorf("Note() = %q, want the redacted section", p.Note())
+	}
+	resp := p.restore(messageResponse{Content: []contentBlock{{Type: "text", Text: "reply to REDACTED-EMAIL-1"}}})
+	if got := resp.Text(); got != "reply to jane@customer.com" {
+		t.Errorf("restore() = %q", got)
+	}
+}
+
+func Test_generate_redaction(t *testing.T) {
+	var sent string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var data struct {
+			Messages []struct {
+				Content string `json:"content"`
+			} `json:"messages"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+			t.Error(err)
+		}
+		sent = data.Messages[0].Content
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"id":"1","content":[{"type":"text","text":"<commit-message>\nfix: notify REDACTED-EMAIL-1 with REDACTED-SECRET-1\n</commit-message>"}],"stop_reason":"end_turn"}`)
+	}))
+	defer ts.Close()
+	defer func(endpoint string, models []modelSpec, redaction redactor) {
+		Endpoint, Models, Redaction = endpoint, models, redaction
+	}(Endpoint, Models, Redaction)
+	Endpoint = ts.URL
+	Models = []modelSpec{{Name: "claude"}}
+	Redaction = redactor{
+		Emails:   true,
+		Patterns: []*regexp.Regexp{regexp.MustCompile(`sk-\w+`)},
+		Restore:  true,
+	}
+	t.Setenv("ANTHROPIC_API_KEY", "test-api-key")
+
+	gen, err := generate(context.Background(), "main", "+to: jane@customer.com\n+key: sk-live123\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(sent, "jane@customer.com") || strings.Contains(sent, "sk-live123") {
+		t.Errorf("prompt was not redacted:\n%s", sent)
+	}
+	if !strings.Contains(sent, "<redacted>") {
+		t.Errorf("prompt does not explain the placeholders:\n%s", sent)
+	}
+	want := "<commit-message>\nfix: notify jane@customer.com with REDACTED-SECRET-1\n</commit-message>"
+	if got := gen.Response.Text(); got != want {
+		t.Errorf("generate() text = %q, want %q", got, want)
+	}
+
+	if _, err := generate(context.Background(), "main", "+retries: 3\n"); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(sent, "<redacted>") || strings.Contains(sent, "REDACTED-") {
+		t.Errorf("prompt explains placeholders when nothing was redacted:\n%s", sent)
+	}
+}
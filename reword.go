@@ -0,0 +1,351 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// emptyTree is the hash of the tree with nothing in it, which root commits
+// are compared to.
+const emptyTree = "4b825dc642cb6eb9a060e54bf8d69288fbee4904"
+
+// rewordCommit is a commit of the range to reword, and its new message.
+type rewordCommit struct {
+	Hash     string
+	Parent   string // empty for a root commit
+	Original string
+	Message  string
+}
+
+// rewordCommand regenerates the messages of the commits in a range that ends
+// at HEAD, lets the user review them, and rewrites the branch with them.
+func rewordCommand(ctx context.Context, config gitConfig, args []string) int {
+	flags := flag.NewFlagSet("commitgpt reword", flag.ContinueOnError)
+	yes := flags.Bool("yes", false, "rewrite the branch without reviewing the messages")
+	batch := flags.Bool("batch", false, "send the requests through the Message Batches API")
+	noBatch := flags.Bool("no-batch", false, "send the requests one at a time")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "usage: commitgpt reword [--yes] [--batch | --no-batch] <from>..HEAD")
+		return 2
+	}
+
+	useBatch := func(n int) bool { return *batch || (!*noBatch && n >= BatchThreshold) }
+	if err := runReword(ctx, flags.Arg(0), useBatch, *yes); err != nil {
+		fmt.Fprintln(os.Stderr, "commitgpt reword:", err)
+		return 1
+	}
+	return 0
+}
+
+func runReword(ctx context.Context, rev string, useBatch func(n int) bool, yes bool) error {
+	commits, err := rewordRange(ctx, rev)
+	if err != nil {
+		return err
+	}
+	if len(commits) == 0 {
+		return fmt.Errorf("there are no commits in %s", rev)
+	}
+	if err := generateRewords(ctx, commits, useBatch(len(commits))); err != nil {
+		return err
+	}
+
+	if !yes {
+		if stat, err := os.Stdin.Stat(); err != nil || stat.Mode()&os.ModeCharDevice == 0 {
+			fmt.Print(formatRewords(commits))
+			fmt.Println("# Run with --yes to rewrite the branch with these messages.")
+			return nil
+		}
+		if commits, err = reviewRewords(ctx, commits); err != nil {
+			return err
+		}
+	}
+
+	oldTip := commits[len(commits)-1].Hash
+	newTip, err := replayCommits(ctx, commits)
+	if err != nil {
+		return err
+	}
+	if newTip == oldTip {
+		fmt.Println("No messages changed.")
+		return nil
+	}
+	if _, err := git(ctx, "update-ref", "-m", "commitgpt reword", "HEAD", newTip, oldTip); err != nil {
+		return err
+	}
+	fmt.Printf("Rewrote %d commits. The old branch is %s, also in the reflog.\n", len(commits), oldTip[:7])
+	return nil
+}
+
+// rewordRange returns the commits in rev, oldest first. The range must end at
+// HEAD and have no merges.
+func rewordRange(ctx context.Context, rev string) ([]rewordCommit, error) {
+	from, to, err := parseRevRange(ctx, rev)
+	if err != nil {
+		return nil, err
+	}
+	head, err := git(ctx, "rev-parse", "--verify", "HEAD")
+	if err != nil {
+		return nil, err
+	}
+	tip, err := git(ctx, "rev-parse", "--verify", to+"^{commit}")
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(head, tip) {
+		return nil, fmt.Errorf("%s does not end at HEAD; check out the branch to reword", rev)
+	}
+	out, err := git(ctx, "rev-list", "--reverse", "--parents", revisionRange(from, to))
+	if err != nil {
+		return nil, err
+	}
+	var commits []rewordCommit
+	for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) > 2 {
+			return nil, fmt.Errorf("%s is a merge, which cannot be reworded", fields[0][:7])
+		}
+		c := rewordCommit{Hash: fields[0]}
+		if len(fields) == 2 {
+			c.Parent = fields[1]
+		}
+		if len(commits) > 0 && c.Parent != commits[len(commits)-1].Hash {
+			return nil, fmt.Errorf("the history of %s is not linear", rev)
+		}
+		message, err := git(ctx, "log", "-1", "--format=%B", c.Hash)
+		if err != nil {
+			return nil, err
+		}
+		c.Original = strings.TrimSpace(string(message))
+		commits = append(commits, c)
+	}
+	return commits, nil
+}
+
+// rewordPrompt returns the prompt for a new message for c, and its redacted
+// parts.
+func rewordPrompt(ctx context.Context, branch string, c rewordCommit) (string, redactedPrompt, error) {
+	base := c.Parent
+	if base == "" {
+		base = emptyTree
+	}
+	diff, err := rangeDiff(ctx, revRange{Base: base, Head: c.Hash})
+	if err != nil {
+		return "", redactedPrompt{}, err
+	}
+	original := "<original-message>\nThe commit is being reworded. This was its message. Keep any issue references or\n" +
+		"trailers in it, and any intent it explains that the diff does not show.\n" + c.Original + "\n</original-message>\n"
+	p := redactPrompt(diff, original)
+	return buildPrompt(branch, p.Parts[0], false, p.Parts[1], p.Note()), p, nil
+}
+
+// generateRewords sets the new message of each of commits, one request at a
+// time, or all in one batch to the first model. Those the batch fails are
+// then tried one at a time, with every model. A commit whose message could
+// not be generated keeps its original message.
+func generateRewords(ctx context.Context, commits []rewordCommit, batch bool) error {
+	branch, err := git(ctx, "rev-parse", "--abbrev-ref", "HEAD")
+	if err != nil {
+		return err
+	}
+	prompts := make([]string, len(commits))
+	redacted := make([]redactedPrompt, len(commits))
+	for i, c := range commits {
+		if prompts[i], redacted[i], err = rewordPrompt(ctx, strings.TrimSpace(string(branch)), c); err != nil {
+			return err
+		}
+	}
+
+	responses := make([]messageResponse, len(commits))
+	errs := make([]error, len(commits))
+	done := make([]bool, len(commits))
+	if batch {
+		if len(Models) == 0 {
+			return errors.New("no model is configured")
+		}
+		// Whatever the batch fails to generate is generated one at a time
+		// below, with the fallback models.
+		var batchErrs []error
+		if responses, batchErrs, err = sendBatch(ctx, Models[0], prompts); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			fmt.Fprintf(os.Stderr, "commitgpt: batch: %v; generating one at a time\n", err)
+			responses = make([]messageResponse, len(commits))
+		}
+		for i := range batchErrs {
+			if batchErrs[i] != nil {
+				fmt.Fprintf(os.Stderr, "commitgpt: %s: batch: %v\n", commits[i].Hash[:7], batchErrs[i])
+				continue
+			}
+			responses[i] = redacted[i].restore(responses[i])
+			done[i] = true
+		}
+	}
+	for i, prompt := range prompts {
+		if done[i] {
+			continue
+		}
+		fmt.Fprintf(os.Stderr, "commitgpt: generating %d of %d\n", i+1, len(prompts))
+		var gen generation
+		gen, errs[i] = redacted[i].ask(ctx, func(model modelSpec) (messageResponse, error) {
+			return sendPrompt(ctx, model, prompt, false)
+		})
+		responses[i] = gen.Response
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+	}
+
+	for i := range commits {
+		commits[i].Message = commits[i].Original
+		if errs[i] != nil {
+			fmt.Fprintf(os.Stderr, "commitgpt: %s: keeping the original message: %v\n", commits[i].Hash[:7], errs[i])
+			continue
+		}
+		_, _, _, message := extractMessages(responses[i].Text())
+		if message = strings.TrimSpace(message); message != "" {
+			commits[i].Message = message
+		}
+	}
+	return nil
+}
+
+// rewordMarker separates the messages in the review file.
+var rewordMarker = regexp.MustCompile(`^# -{8} (\d+)/\d+ [0-9a-f]+ -{8}$`)
+
+// formatRewords writes the new messages for review, each after a marker line
+// and its original subject.
+func formatRewords(commits []rewordCommit) string {
+	var b strings.Builder
+	b.WriteString("# Review the new commit messages below, oldest first. Lines starting\n")
+	b.WriteString("# with '#' are ignored. An empty message keeps the original, and\n")
+	b.WriteString("# emptying every message aborts the reword.\n")
+	for i, c := range commits {
+		subject, _, _ := strings.Cut(c.Original, "\n")
+		fmt.Fprintf(&b, "\n# -------- %d/%d %s --------\n", i+1, len(commits), c.Hash[:7])
+		fmt.Fprintf(&b, "# Original: %s\n", subject)
+		b.WriteString(c.Message + "\n")
+	}
+	return b.String()
+}
+
+// parseRewords reads the messages back from the review file. It reports
+// false when every message is empty.
+func parseRewords(commits []rewordCommit, review string) ([]rewordCommit, bool) {
+	messages := map[int]*strings.Builder{}
+	var current *strings.Builder
+	for _, line := range strings.Split(review, "\n") {
+		if m := rewordMarker.FindStringSubmatch(line); m != nil {
+			i, _ := strconv.Atoi(m[1])
+			current = &strings.Builder{}
+			messages[i-1] = current
+			continue
+		}
+		if current == nil || strings.HasPrefix(line, "#") {
+			continue
+		}
+		current.WriteString(line + "\n")
+	}
+
+	reworded := append([]rewordCommit(nil), commits...)
+	changed := false
+	for i := range reworded {
+		message := ""
+		if b, ok := messages[i]; ok {
+			message = strings.TrimSpace(b.String())
+		}
+		if message == "" {
+			reworded[i].Message = reworded[i].Original
+			continue
+		}
+		reworded[i].Message = message
+		changed = true
+	}
+	return reworded, changed
+}
+
+// reviewRewords opens the new messages in the editor that git uses.
+func reviewRewords(ctx context.Context, commits []rewordCommit) ([]rewordCommit, error) {
+	file, err := git(ctx, "rev-parse", "--git-path", "COMMITGPT_REWORD")
+	if err != nil {
+		return nil, err
+	}
+	path := strings.TrimSpace(string(file))
+	if err := os.WriteFile(path, []byte(formatRewords(commits)), 0644); err != nil {
+		return nil, err
+	}
+	defer os.Remove(path)
+
+	editor, err := git(ctx, "var", "GIT_EDITOR")
+	if err != nil {
+		return nil, err
+	}
+	// The editor is a shell command, as git runs it.
+	cmd := exec.CommandContext(ctx, "sh", "-c", strings.TrimSpace(string(editor))+` "$@"`, "editor", path)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("editor: %w", err)
+	}
+	review, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	reworded, changed := parseRewords(commits, string(review))
+	if !changed {
+		return nil, errors.New("every message is empty; aborting")
+	}
+	return reworded, nil
+}
+
+// replayCommits recreates commits with their new messages on top of the
+// parent of the first, keeping their trees and authors, and returns the new
+// tip. Commits before the first changed message are kept as they are.
+func replayCommits(ctx context.Context, commits []rewordCommit) (string, error) {
+	parent := commits[0].Parent
+	rewritten := false
+	for _, c := range commits {
+		if !rewritten && c.Message == c.Original {
+			parent = c.Hash
+			continue
+		}
+		rewritten = true
+		author, err := git(ctx, "log", "-1", "--format=%an%x00%ae%x00%ad", "--date=raw", c.Hash)
+		if err != nil {
+			return "", err
+		}
+		fields := strings.SplitN(strings.TrimSuffix(string(author), "\n"), "\x00", 3)
+		if len(fields) != 3 {
+			return "", fmt.Errorf("%s: cannot read the author", c.Hash[:7])
+		}
+		args := []string{"commit-tree", c.Hash + "^{tree}"}
+		if parent != "" {
+			args = append(args, "-p", parent)
+		}
+		cmd := exec.CommandContext(ctx, "git", append(args, "-F", "-")...)
+		cmd.Stdin = strings.NewReader(c.Message + "\n")
+		cmd.Env = append(os.Environ(),
+			"GIT_AUTHOR_NAME="+fields[0], "GIT_AUTHOR_EMAIL="+fields[1], "GIT_AUTHOR_DATE="+fields[2])
+		var stderr bytes.Buffer
+		cmd.Stderr = &stderr
+		out, err := cmd.Output()
+		if err != nil {
+			return "", fmt.Errorf("git commit-tree: %v: %s", err, bytes.TrimSpace(stderr.Bytes()))
+		}
+		parent = strings.TrimSpace(string(out))
+	}
+	return parent, nil
+}
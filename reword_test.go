@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func Test_parseRewords(t *testing.T) {
+	commits := []rewordCommit{
+		{Hash: "1111111aaaa", Original: "wip", Message: "feat: add a"},
+		{Hash: "2222222bbbb", Original: "fix\n\nstuff", Message: "fix: b\n\nBody."},
+	}
+	review := formatRewords(commits)
+	if !strings.Contains(review, "# -------- 2/2 2222222 --------\n# Original: fix\nfix: b\n\nBody.\n") {
+		t.Errorf("formatRewords() = %q", review)
+	}
+	got, ok := parseRewords(commits, review)
+	if !ok {
+		t.Fatal("parseRewords() reports every message empty")
+	}
+	if diff := cmp.Diff(commits, got); diff != "" {
+		t.Errorf("parseRewords() mismatch (-want +got):\n%s", diff)
+	}
+
+	edited := strings.Replace(review, "feat: add a\n", "# feat: add a\n", 1)
+	edited = strings.Replace(edited, "fix: b\n", "fix(b): handle b\n", 1)
+	got, ok = parseRewords(commits, edited)
+	want := []rewordCommit{
+		{Hash: "1111111aaaa", Original: "wip", Message: "wip"},
+		{Hash: "2222222bbbb", Original: "fix\n\nstuff", Message: "fix(b): handle b\n\nBody."},
+	}
+	if diff := cmp.Diff(want, got); !ok || diff != "" {
+		t.Errorf("parseRewords() mismatch (-want +got):\n%s", diff)
+	}
+
+	if _, ok := parseRewords(commits, "# -------- 1/2 1111111 --------\n\n# -------- 2/2 2222222 --------\n"); ok {
+		t.Error("parseRewords() of empty messages reports a change")
+	}
+}
+
+func Test_runReword(t *testing.T) {
+	fakeAPI(t, "<commit-message>\nfeat: describe the change\n\nWith a body.\n</commit-message>")
+	ctx := context.Background()
+	chdirTempRepo(t)
+	stageFiles(t, map[string]string{"a": "a"})
+	commitStaged(t, "first")
+	stageFiles(t, map[string]string{"b": "b"})
+	commitStaged(t, "wip")
+	stageFiles(t, map[string]string{"c": "c"})
+	commitStaged(t, "fix")
+	oldTree, _ := git(ctx, "rev-parse", "HEAD^{tree}")
+	oldRoot, _ := git(ctx, "rev-parse", "HEAD~2")
+
+	if err := runReword(ctx, "HEAD~3..HEAD~1", func(int) bool { return false }, true); err == nil || !strings.Contains(err.Error(), "does not end at HEAD") {
+		t.Errorf("runReword() err = %v, want a range that does not end at HEAD", err)
+	}
+	t.Setenv("GIT_COMMITTER_NAME", "b")
+	t.Setenv("GIT_COMMITTER_EMAIL", "b@example.com")
+	if err := runReword(ctx, "HEAD~2..", func(int) bool { return false }, true); err != nil {
+		t.Fatal(err)
+	}
+
+	log, _ := git(ctx, "log", "--format=%s|%an")
+	if got := string(log); got != "feat: describe the change|a\nfeat: describe the change|a\nfirst|a\n" {
+		t.Errorf("log = %q", got)
+	}
+	newTree, _ := git(ctx, "rev-parse", "HEAD^{tree}")
+	if string(newTree) != string(oldTree) {
+		t.Errorf("the tree changed from %s to %s", oldTree, newTree)
+	}
+	if root, _ := git(ctx, "rev-parse", "HEAD~2"); string(root) != string(oldRoot) {
+		t.Error("the commit before the range was rewritten")
+	}
+	if out, _ := git(ctx, "status", "--porcelain"); len(out) != 0 {
+		t.Errorf("the work tree changed: %s", out)
+	}
+}
+
+func Test_generateRewords_batchFallback(t *testing.T) {
+	var single int
+	var ts *httptest.Server
+	ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == "POST" && r.URL.Path == "/v1/messages/batches":
+			fmt.Fprint(w, `{"id": "msgbatch_1", "processing_status": "ended", "results_url": "`+ts.URL+`/results"}`)
+		case r.Method == "GET" && r.URL.Path == "/v1/messages/batches/msgbatch_1":
+			fmt.Fprintf(w, `{"id": "msgbatch_1", "processing_status": "ended", "results_url": %q}`, ts.URL+"/results")
+		case r.Method == "GET" && r.URL.Path == "/results":
+			fmt.Fprintln(w, `{"custom_id": "request-0", "result": {"type": "succeeded", "message": {"stop_reason": "end_turn", "content": [{"type": "text", "text": "<commit-message>\nfeat: from the batch\n</commit-message>"}]}}}`)
+			fmt.Fprintln(w, `{"custom_id": "request-1", "result": {"type": "expired"}}`)
+		case r.Method == "POST" && r.URL.Path == "/v1/messages":
+			single++
+			fmt.Fprint(w, `{"id": "1", "stop_reason": "end_turn", "content": [{"type": "text", "text": "<commit-message>\nfeat: on its own\n</commit-message>"}]}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer ts.Close()
+	defer func(endpoint string, interval time.Duration) {
+		Endpoint, batchPollInterval = endpoint, interval
+	}(Endpoint, batchPollInterval)
+	Endpoint, batchPollInterval = ts.URL+"/v1/messages", time.Millisecond
+	t.Setenv("ANTHROPIC_API_KEY", "test-api-key")
+
+	ctx := context.Background()
+	chdirTempRepo(t)
+	stageFiles(t, map[string]string{"a": "a"})
+	commitStaged(t, "first")
+	stageFiles(t, map[string]string{"b": "b"})
+	commitStaged(t, "second")
+	commits, err := rewordRange(ctx, "HEAD~1..")
+	if err != nil {
+		t.Fatal(err)
+	}
+	root, _ := git(ctx, "rev-list", "--max-parents=0", "HEAD")
+	commits = append([]rewordCommit{{Hash: strings.TrimSpace(string(root)), Original: "first"}}, commits...)
+
+	if err := generateRewords(ctx, commits, true); err != nil {
+		t.Fatal(err)
+	}
+	if single != 1 {
+		t.Errorf("sent %d requests on their own, want 1 for the expired one", single)
+	}
+	if got := []string{commits[0].Message, commits[1].Message}; got[0] != "feat: from the batch" || got[1] != "feat: on its own" {
+		t.Errorf("messages = %q", got)
+	}
+}
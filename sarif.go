@@ -0,0 +1,333 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// sarifRule is a kind of finding that the model reports in its response.
+type sarifRule struct {
+	ID               string `json:"id"`
+	ShortDescription struct {
+		Text string `json:"text"`
+	} `json:"shortDescription"`
+	Help struct {
+		Text string `json:"text"`
+	} `json:"help"`
+	DefaultConfiguration struct {
+		Level string `json:"level"`
+	} `json:"defaultConfiguration"`
+}
+
+func newSARIFRule(id, level, description, help string) sarifRule {
+	var r sarifRule
+	r.ID = id
+	r.ShortDescription.Text = description
+	r.Help.Text = help
+	r.DefaultConfiguration.Level = level
+	return r
+}
+
+var sarifRules = []sarifRule{
+	newSARIFRule("sensitive-info", "error", "Sensitive information in the diff",
+		"Remove the API key, password, token or personal data from the change and commit again."),
+	newSARIFRule("large-file", "warning", "File larger than 50MB in the diff",
+		"Store the file with Git Large File Storage instead: https://git-lfs.github.com"),
+}
+
+type sarifRegion struct {
+	StartLine int `json:"startLine"`
+}
+
+type sarifLocation struct {
+	PhysicalLocation struct {
+		ArtifactLocation struct {
+			URI       string `json:"uri"`
+			URIBaseID string `json:"uriBaseId"`
+		} `json:"artifactLocation"`
+		Region *sarifRegion `json:"region,omitempty"`
+	} `json:"physicalLocation"`
+}
+
+type sarifResult struct {
+	RuleID  string `json:"ruleId"`
+	Level   string `json:"level"`
+	Message struct {
+		Text string `json:"text"`
+	} `json:"message"`
+	Locations []sarifLocation `json:"locations,omitempty"`
+}
+
+type sarifRun struct {
+	Tool struct {
+		Driver struct {
+			Name           string      `json:"name"`
+			InformationURI string      `json:"informationUri"`
+			Rules          []sarifRule `json:"rules"`
+		} `json:"driver"`
+	} `json:"tool"`
+	Results []sarifResult `json:"results"`
+}
+
+// sarifLog is a SARIF 2.1.0 log with a single run of commitgpt.
+type sarifLog struct {
+	Schema  string     `json:"$schema"`
+	Version string     `json:"version"`
+	Runs    []sarifRun `json:"runs"`
+}
+
+// fileLines are the lines of the new file that the hunks of a diffFile
+// cover, and the text of the lines they add.
+type fileLines struct {
+	Path   string
+	Ranges [][2]int // first and last line
+	Added  map[int]string
+}
+
+var hunkHeaderPattern = regexp.MustCompile(`^@@ -\d+(?:,\d+)? \+(\d+)(?:,(\d+))? @@`)
+
+// diffLines maps the hunks of each file in diff to lines of the new file.
+func diffLines(diff string) []fileLines {
+	var files []fileLines
+	for _, f := range parsePatch(diff) {
+		lines := fileLines{Path: f.Path, Added: map[int]string{}}
+		for _, hunk := range f.Hunks {
+			text := strings.Split(strings.TrimSuffix(hunk, "\n"), "\n")
+			m := hunkHeaderPattern.FindStringSubmatch(text[0])
+			if m == nil {
+				continue
+			}
+			start, _ := strconv.Atoi(m[1])
+			count := 1
+			if m[2] != "" {
+				count, _ = strconv.Atoi(m[2])
+			}
+			lines.Ranges = append(lines.Ranges, [2]int{start, start + count - 1})
+			n := start
+			for _, line := range text[1:] {
+				switch {
+				case strings.HasPrefix(line, "+"):
+					lines.Added[n] = line[1:]
+					n++
+				case strings.HasPrefix(line, " "):
+					n++
+				}
+			}
+		}
+		files = append(files, lines)
+	}
+	return files
+}
+
+// covers reports whether a hunk of f covers line.
+func (f fileLines) covers(line int) bool {
+	for _, h := range f.Ranges {
+		if line >= h[0] && line <= h[1] {
+			return true
+		}
+	}
+	return false
+}
+
+var (
+	findingLinePattern  = regexp.MustCompile(`(?i)\blines?\s+(\d+)`)
+	findingQuotePattern = regexp.MustCompile("`([^`]+)`|\"([^\"]+)\"|'([^']{4,})'")
+)
+
+// locateFinding finds the file and line of the new file that a finding from
+// the model refers to. The file is the longest path of the diff named in the
+// finding, or the only one. The line is the line number it gives, if a hunk
+// covers it, or else the first added line that contains a quote from the
+// finding. The line is 0 if neither is found.
+func locateFinding(text string, files []fileLines) (string, int) {
+	var file *fileLines
+	for i := range files {
+		if strings.Contains(text, files[i].Path) && (file == nil || len(files[i].Path) > len(file.Path)) {
+			file = &files[i]
+		}
+	}
+	if file == nil {
+		// The file may be named without its directory, if no other file has
+		// the same name.
+		words := map[string]bool{}
+		for _, word := range strings.Fields(text) {
+			words[strings.Trim(word, "`'\"()[],:;")] = true
+		}
+		for i := range files {
+			if words[path.Base(files[i].Path)] {
+				if file != nil {
+					file = nil
+					break
+				}
+				file = &files[i]
+			}
+		}
+	}
+	if file == nil && len(files) == 1 {
+		file = &files[0]
+	}
+
+	if m := findingLinePattern.FindStringSubmatch(text); m != nil {
+		n, _ := strconv.Atoi(m[1])
+		if file != nil && file.covers(n) {
+			return file.Path, n
+		}
+		if file == nil {
+			var covering []*fileLines
+			for i := range files {
+				if files[i].covers(n) {
+					covering = append(covering, &files[i])
+				}
+			}
+			if len(covering) == 1 {
+				return covering[0].Path, n
+			}
+		}
+	}
+
+	candidates := files
+	if file != nil {
+		candidates = []fileLines{*file}
+	}
+	for _, m := range findingQuotePattern.FindAllStringSubmatch(text, -1) {
+		quote := m[1] + m[2] + m[3]
+		if strings.TrimSpace(quote) == "" {
+			continue
+		}
+		for _, f := range candidates {
+			best := 0
+			for n, added := range f.Added {
+				if strings.Contains(added, quote) && (best == 0 || n < best) {
+					best = n
+				}
+			}
+			if best > 0 {
+				return f.Path, best
+			}
+		}
+	}
+	if file != nil {
+		return file.Path, 0
+	}
+	return "", 0
+}
+
+// warningItems splits a warning from the model into its bulleted items. A
+// warning without bullets is a single item.
+func warningItems(warning string) []string {
+	var items []string
+	for _, line := range strings.Split(warning, "\n") {
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(trimmed, "- ") || strings.HasPrefix(trimmed, "* "):
+			items = append(items, strings.TrimSpace(trimmed[2:]))
+		case trimmed == "":
+		case len(items) > 0 && line != trimmed:
+			// An indented line continues the item.
+			items[len(items)-1] += " " + trimmed
+		}
+	}
+	if len(items) == 0 && strings.TrimSpace(warning) != "" {
+		items = []string{strings.TrimSpace(warning)}
+	}
+	return items
+}
+
+// warningFindings returns the bulleted items of a warning from the model. A
+// warning that lists nothing with "- ", such as the model saying that it
+// found nothing, has no findings.
+func warningFindings(warning string) []string {
+	for _, line := range strings.Split(warning, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "- ") || strings.HasPrefix(trimmed, "* ") {
+			return warningItems(warning)
+		}
+	}
+	return nil
+}
+
+// newSARIFLog returns the findings in the sensitive information and large
+// files warnings of a response as a SARIF log, located in diff.
+func newSARIFLog(diff, sensitiveWarn, largeFilesWarn string) sarifLog {
+	var log sarifLog
+	log.Schema = "https://json.schemastore.org/sarif-2.1.0.json"
+	log.Version = "2.1.0"
+	log.Runs = make([]sarifRun, 1)
+	run := &log.Runs[0]
+	run.Tool.Driver.Name = "commitgpt"
+	run.Tool.Driver.InformationURI = "https://github.com/au-phiware/commitgpt"
+	run.Tool.Driver.Rules = sarifRules
+	run.Results = []sarifResult{}
+
+	files := diffLines(diff)
+	for _, w := range []struct{ rule, warning string }{
+		{"sensitive-info", sensitiveWarn},
+		{"large-file", largeFilesWarn},
+	} {
+		for _, item := range warningFindings(w.warning) {
+			result := sarifResult{RuleID: w.rule}
+			result.Message.Text = item
+			for _, r := range sarifRules {
+				if r.ID == w.rule {
+					result.Level = r.DefaultConfiguration.Level
+				}
+			}
+			if file, line := locateFinding(item, files); file != "" {
+				var loc sarifLocation
+				loc.PhysicalLocation.ArtifactLocation.URI = (&url.URL{Path: file}).String()
+				loc.PhysicalLocation.ArtifactLocation.URIBaseID = "%SRCROOT%"
+				if line > 0 {
+					loc.PhysicalLocation.Region = &sarifRegion{StartLine: line}
+				}
+				result.Locations = []sarifLocation{loc}
+			}
+			run.Results = append(run.Results, result)
+		}
+	}
+	return log
+}
+
+func writeSARIF(w io.Writer, log sarifLog) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(log)
+}
+
+// writeSARIFFile writes the SARIF log of the warnings to file.
+func writeSARIFFile(file, diff, sensitiveWarn, largeFilesWarn string) error {
+	f, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	if err := writeSARIF(f, newSARIFLog(diff, sensitiveWarn, largeFilesWarn)); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// archiveSARIF writes the SARIF log of the warnings to <tree>.sarif in
+// ANTHROPIC_LOG_DIR, next to the message log, when they list any findings.
+func archiveSARIF(ctx context.Context, diff, sensitiveWarn, largeFilesWarn string) error {
+	logDir := os.Getenv("ANTHROPIC_LOG_DIR")
+	if logDir == "" || (warningFindings(sensitiveWarn) == nil && warningFindings(largeFilesWarn) == nil) {
+		return nil
+	}
+	if err := os.MkdirAll(logDir, 0755); err != nil {
+		return err
+	}
+	tree, err := git(ctx, "write-tree")
+	if err != nil {
+		return err
+	}
+	file := filepath.Join(logDir, strings.TrimSpace(string(tree))+".sarif")
+	return writeSARIFFile(file, diff, sensitiveWarn, largeFilesWarn)
+}
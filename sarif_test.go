@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+const sarifTestDiff = `diff --git a/config/app.yaml b/config/app.yaml
+index 34c6f64..9f3a73e 100644
+--- a/config/app.yaml
++++ b/config/app.yaml
+@@ -5,4 +5,5 @@ server:
+   host: example.com
+-  token: ""
++  token: "sk-live-1234"
++  timeout: 30
+   port: 80
+@@ -20 +21,2 @@ client:
++  password: hunter2
+   retries: 3
+diff --git a/src/app.yaml b/src/app.yaml
+new file mode 100644
+index 0000000..c1b1e4c
+--- /dev/null
++++ b/src/app.yaml
+@@ -0,0 +1 @@
++name: app
+diff --git a/data/model.bin b/data/model.bin
+new file mode 100644
+index 0000000..c1b1e4c
+Binary files /dev/null and b/data/model.bin differ
+`
+
+func Test_diffLines(t *testing.T) {
+	want := []fileLines{
+		{Path: "config/app.yaml", Ranges: [][2]int{{5, 9}, {21, 22}}, Added: map[int]string{
+			6: `  token: "sk-live-1234"`, 7: "  timeout: 30", 21: "  password: hunter2",
+		}},
+		{Path: "src/app.yaml", Ranges: [][2]int{{1, 1}}, Added: map[int]string{1: "name: app"}},
+		{Path: "data/model.bin", Added: map[int]string{}},
+	}
+	if diff := cmp.Diff(want, diffLines(sarifTestDiff)); diff != "" {
+		t.Errorf("diffLines() mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func Test_locateFinding(t *testing.T) {
+	files := diffLines(sarifTestDiff)
+	tests := []struct {
+		text     string
+		wantPath string
+		wantLine int
+	}{
+		{"config/app.yaml, line 6: API token", "config/app.yaml", 6},
+		{"Line 21 of config/app.yaml has a password", "config/app.yaml", 21},
+		{"config/app.yaml, line 40: outside the hunks", "config/app.yaml", 0},
+		{"config/app.yaml: the token `sk-live-1234` is hardcoded", "config/app.yaml", 6},
+		{"The password \"hunter2\" is hardcoded", "config/app.yaml", 21},
+		{"line 22: a password", "config/app.yaml", 22},
+		{"line 1: a name", "src/app.yaml", 1},
+		{"model.bin (120MB)", "data/model.bin", 0},
+		{"app.yaml: a name in one of them", "", 0},
+		{"something else entirely", "", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			path, line := locateFinding(tt.text, files)
+			if path != tt.wantPath || line != tt.wantLine {
+				t.Errorf("locateFinding() = %q, %d, want %q, %d", path, line, tt.wantPath, tt.wantLine)
+			}
+		})
+	}
+}
+
+func Test_warningItems(t *testing.T) {
+	tests := []struct {
+		warning string
+		want    []string
+	}{
+		{"", nil},
+		{"Warning: the diff has a key in a.go.", []string{"Warning: the diff has a key in a.go."}},
+		{
+			"Warning: The diff contains sensitive information:\n- a.go, line 5: key\n  in a comment\n* b.go: token\n\nPlease remove it.",
+			[]string{"a.go, line 5: key in a comment", "b.go: token"},
+		},
+	}
+	for _, tt := range tests {
+		if diff := cmp.Diff(tt.want, warningItems(tt.warning)); diff != "" {
+			t.Errorf("warningItems(%q) mismatch (-want +got):\n%s", tt.warning, diff)
+		}
+	}
+}
+
+func Test_warningFindings(t *testing.T) {
+	tests := []struct {
+		name    string
+		warning string
+		want    []string
+	}{
+		{
+			name: "empty",
+		},
+		{
+			name:    "nothing found",
+			warning: "No sensitive information was found in the diff.",
+		},
+		{
+			name:    "findings",
+			warning: "Warning: The diff contains sensitive information:\n- config.yaml, line 3: An AWS key\n- .env, line 1: A password\n\nPlease remove it.",
+			want:    []string{"config.yaml, line 3: An AWS key", ".env, line 1: A password"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if diff := cmp.Diff(tt.want, warningFindings(tt.warning)); diff != "" {
+				t.Errorf("warningFindings() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func Test_newSARIFLog(t *testing.T) {
+	log := newSARIFLog(sarifTestDiff,
+		"Warning: The diff contains sensitive information:\n- config/app.yaml, line 6: API token\n- A password somewhere",
+		"Warning: The diff contains files larger than 50MB:\n- data/model.bin (120MB)")
+	out, err := json.Marshal(log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got struct {
+		Version string `json:"version"`
+		Runs    []struct {
+			Results []struct {
+				RuleID    string `json:"ruleId"`
+				Level     string `json:"level"`
+				Locations []struct {
+					PhysicalLocation struct {
+						ArtifactLocation struct {
+							URI string `json:"uri"`
+						} `json:"artifactLocation"`
+						Region struct {
+							StartLine int `json:"startLine"`
+						} `json:"region"`
+					} `json:"physicalLocation"`
+				} `json:"locations"`
+			} `json:"results"`
+		} `json:"runs"`
+	}
+	if err := json.Unmarshal(out, &got); err != nil {
+		t.Fatal(err)
+	}
+	results := got.Runs[0].Results
+	if got.Version != "2.1.0" || len(results) != 3 {
+		t.Fatalf("newSARIFLog() = %s", out)
+	}
+	if r := results[0]; r.RuleID != "sensitive-info" || r.Level != "error" ||
+		r.Locations[0].PhysicalLocation.ArtifactLocation.URI != "config/app.yaml" ||
+		r.Locations[0].PhysicalLocation.Region.StartLine != 6 {
+		t.Errorf("result 0 = %+v", r)
+	}
+	if r := results[1]; len(r.Locations) != 0 {
+		t.Errorf("result 1 = %+v, want no location", r)
+	}
+	if r := results[2]; r.RuleID != "large-file" || r.Level != "warning" ||
+		r.Locations[0].PhysicalLocation.ArtifactLocation.URI != "data/model.bin" ||
+		strings.Contains(string(out), `"startLine":0`) {
+		t.Errorf("result 2 = %+v", r)
+	}
+}
+
+func Test_archiveSARIF(t *testing.T) {
+	chdirTempRepo(t)
+	ctx := context.Background()
+	logDir := filepath.Join(t.TempDir(), "logs")
+	t.Setenv("ANTHROPIC_LOG_DIR", logDir)
+
+	if err := archiveSARIF(ctx, sarifTestDiff, "No sensitive information was found in the diff.", ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(logDir); !os.IsNotExist(err) {
+		t.Errorf("archiveSARIF() wrote without findings: %v", err)
+	}
+
+	if err := archiveSARIF(ctx, sarifTestDiff, "- config/app.yaml, line 6: API token", ""); err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(logDir, emptyTree+".sarif")
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), `"ruleId": "sensitive-info"`) {
+		t.Errorf("%s = %s", file, data)
+	}
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// scopeRule gives the paths matched by Pattern the conventional commit scope
+// Scope.
+type scopeRule struct {
+	Pattern string
+	Scope   string
+}
+
+// ScopeRules are configured with commitgpt.scopeRule, whose values are a path
+// pattern and a scope, as in scopesFile. The rules in scopesFile follow them,
+// and a later rule that matches a path overrides an earlier one.
+var ScopeRules []scopeRule
+
+// ScopeMode is what is done with the inferred scope: "prompt" requires it of
+// the model, "rewrite" also replaces the scope of the subject line with it,
+// and "off" does neither.
+var ScopeMode = "prompt"
+
+// MaxScopes is the most scopes that are given for one commit. Beyond it the
+// model is asked to suggest splitting the commit instead.
+var MaxScopes = 3
+
+// scopesFile is a file at the top of the repository in CODEOWNERS syntax,
+// with a path pattern and a scope on each line.
+const scopesFile = ".commitgptscopes"
+
+// loadScopeRules reads commitgpt.scopeRule, in order. The scope is in the
+// value because git config variable names are case insensitive and cannot
+// contain characters such as "_".
+func loadScopeRules(c gitConfig) ([]scopeRule, error) {
+	var rules []scopeRule
+	for _, value := range c.Strings("commitgpt.scopeRule", "", nil) {
+		fields := strings.Fields(value)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("commitgpt.scopeRule: %q: want a pattern and a scope", value)
+		}
+		rules = append(rules, scopeRule{Pattern: fields[0], Scope: fields[1]})
+	}
+	return rules, nil
+}
+
+// parseScopesFile reads the rules of a scopes file. Blank lines and lines
+// starting with # are skipped.
+func parseScopesFile(data []byte) ([]scopeRule, error) {
+	var rules []scopeRule
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("%s:%d: want a pattern and a scope", scopesFile, n)
+		}
+		rules = append(rules, scopeRule{Pattern: fields[0], Scope: fields[1]})
+	}
+	return rules, scanner.Err()
+}
+
+// matchPattern reports whether the slash separated path p matches pattern, as
+// in CODEOWNERS: * does not cross a slash, ** does, a pattern without a
+// slash matches at any depth, and a pattern that matches a directory matches
+// everything in it.
+func matchPattern(pattern, p string) bool {
+	pattern = strings.TrimSuffix(pattern, "/")
+	if pattern == "" {
+		return false
+	}
+	if strings.HasPrefix(pattern, "/") {
+		pattern = pattern[1:]
+	} else if !strings.Contains(pattern, "/") {
+		pattern = "**/" + pattern
+	}
+	return matchSegments(strings.Split(pattern, "/"), strings.Split(p, "/"))
+}
+
+func matchSegments(pattern, segments []string) bool {
+	if len(pattern) == 0 {
+		// A directory matches everything in it.
+		return true
+	}
+	if pattern[0] == "**" {
+		for i := 0; i <= len(segments); i++ {
+			if matchSegments(pattern[1:], segments[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(segments) == 0 {
+		return false
+	}
+	if ok, _ := path.Match(pattern[0], segments[0]); !ok {
+		return false
+	}
+	return matchSegments(pattern[1:], segments[1:])
+}
+
+// inferScopes returns the scopes of paths, sorted, from the last rule that
+// matches each of them. Paths that no rule matches have no scope.
+func inferScopes(ctx context.Context, paths []string) ([]string, error) {
+	rules := ScopeRules
+	top, err := git(ctx, "rev-parse", "--show-toplevel")
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(filepath.Join(string(bytes.TrimSpace(top)), scopesFile))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err == nil {
+		fileRules, err := parseScopesFile(data)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(append([]scopeRule(nil), rules...), fileRules...)
+	}
+	if len(rules) == 0 {
+		return nil, nil
+	}
+
+	seen := map[string]bool{}
+	var scopes []string
+	for _, p := range paths {
+		scope := ""
+		for _, rule := range rules {
+			if matchPattern(rule.Pattern, p) {
+				scope = rule.Scope
+			}
+		}
+		if scope != "" && !seen[scope] {
+			seen[scope] = true
+			scopes = append(scopes, scope)
+		}
+	}
+	sort.Strings(scopes)
+	return scopes, nil
+}
+
+// scopeSection returns a prompt section that requires the scopes of the
+// staged changes, or suggests splitting the commit when there are more than
+// MaxScopes.
+func scopeSection(scopes []string) string {
+	switch {
+	case ScopeMode == "off" || len(scopes) == 0:
+		return ""
+	case len(scopes) > MaxScopes:
+		return fmt.Sprintf("<scope>\nThe diff changes %d packages: %s. Leave the scope out of the subject line, and\n"+
+			"suggest at the end of the body splitting the commit into one commit per package.\n</scope>\n",
+			len(scopes), strings.Join(scopes, ", "))
+	}
+	return fmt.Sprintf("<scope>\nThe scope of this commit is %q. Write the subject line as type(%s): description.\n</scope>\n",
+		strings.Join(scopes, ","), strings.Join(scopes, ","))
+}
+
+var subjectPattern = regexp.MustCompile(`^(\s*[A-Za-z]+)(\([^)]*\))?(!?:\s)`)
+
+// rewriteScope replaces the scope of the conventional commit subject line in
+// each <commit-message> of text with scopes. Subjects that are not
+// conventional commits are left alone, as is everything when there are more
+// than MaxScopes.
+func rewriteScope(text string, scopes []string) string {
+	if len(scopes) == 0 || len(scopes) > MaxScopes {
+		return text
+	}
+	const tag = "<commit-message>"
+	var out strings.Builder
+	for {
+		i := strings.Index(text, tag)
+		if i < 0 {
+			out.WriteString(text)
+			return out.String()
+		}
+		out.WriteString(text[:i+len(tag)])
+		text = text[i+len(tag):]
+		// Skip the newlines before the subject.
+		start := len(text) - len(strings.TrimLeft(text, "\n"))
+		out.WriteString(text[:start])
+		text = text[start:]
+		if m := subjectPattern.FindStringSubmatchIndex(text); m != nil {
+			out.WriteString(text[m[2]:m[3]])
+			out.WriteString("(" + strings.Join(scopes, ",") + ")")
+			text = text[m[6]:]
+		}
+	}
+}
+
+// rewriteResponseScope rewrites the scope in the text of a response.
+func rewriteResponseScope(resp messageResponse, scopes []string) messageResponse {
+	content := make([]contentBlock, len(resp.Content))
+	for i, block := range resp.Content {
+		block.Text = rewriteScope(block.Text, scopes)
+		content[i] = block
+	}
+	resp.Content = content
+	return resp
+}
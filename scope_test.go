@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func Test_matchPattern(t *testing.T) {
+	tests := []struct {
+		pattern, path string
+		want          bool
+	}{
+		{"services/api/", "services/api/main.go", true},
+		{"services/api", "services/api/handlers/user.go", true},
+		{"/services/api/**", "services/api/main.go", true},
+		{"services/api/", "services/apis/main.go", false},
+		{"services/*/go.mod", "services/web/go.mod", true},
+		{"services/*/go.mod", "services/web/sub/go.mod", false},
+		{"docs/**/*.md", "docs/a/b/c.md", true},
+		{"docs/**/*.md", "docs/c.md", true},
+		{"*.proto", "api/v1/user.proto", true},
+		{"Makefile", "tools/Makefile", true},
+		{"/Makefile", "tools/Makefile", false},
+		{"", "a", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.pattern+" "+tt.path, func(t *testing.T) {
+			if got := matchPattern(tt.pattern, tt.path); got != tt.want {
+				t.Errorf("matchPattern(%q, %q) = %v, want %v", tt.pattern, tt.path, got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_loadScopeRules(t *testing.T) {
+	got, err := loadScopeRules(gitConfig{
+		"commitgpt.scoperule": {
+			{Value: "services/api/ api"},
+			{Value: "*.proto  api_v2"},
+			{Value: "apps/web/ UI"},
+		},
+		"commitgpt.policy.template": {{Value: "abort"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []scopeRule{
+		{Pattern: "services/api/", Scope: "api"},
+		{Pattern: "*.proto", Scope: "api_v2"},
+		{Pattern: "apps/web/", Scope: "UI"},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("loadScopeRules() mismatch (-want +got):\n%s", diff)
+	}
+	if _, err := loadScopeRules(gitConfig{"commitgpt.scoperule": {{Value: "apps/web/"}}}); err == nil {
+		t.Error("loadScopeRules() accepted a rule without a scope")
+	}
+}
+
+func Test_parseScopesFile(t *testing.T) {
+	got, err := parseScopesFile([]byte("# Scopes\n\nservices/api/  api\n*.md docs\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []scopeRule{{Pattern: "services/api/", Scope: "api"}, {Pattern: "*.md", Scope: "docs"}}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("parseScopesFile() mismatch (-want +got):\n%s", diff)
+	}
+	if _, err := parseScopesFile([]byte("services/api/\n")); err == nil || !strings.Contains(err.Error(), ":1:") {
+		t.Errorf("parseScopesFile() err = %v, want line 1 error", err)
+	}
+}
+
+func Test_inferScopes(t *testing.T) {
+	defer func(rules []scopeRule) { ScopeRules = rules }(ScopeRules)
+	ScopeRules = []scopeRule{{Pattern: "services/api/", Scope: "api"}, {Pattern: "apps/web/", Scope: "web"}}
+	chdirTempRepo(t)
+	stageFiles(t, map[string]string{scopesFile: "*.md docs\napps/web/README.md web\n"})
+
+	tests := []struct {
+		paths []string
+		want  []string
+	}{
+		{[]string{"services/api/main.go", "services/api/go.mod"}, []string{"api"}},
+		{[]string{"apps/web/app.ts", "services/api/main.go", "go.work"}, []string{"api", "web"}},
+		{[]string{"services/api/README.md"}, []string{"docs"}},
+		{[]string{"apps/web/README.md"}, []string{"web"}},
+		{[]string{"go.work"}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(strings.Join(tt.paths, " "), func(t *testing.T) {
+			got, err := inferScopes(context.Background(), tt.paths)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("inferScopes() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func Test_scopeSection(t *testing.T) {
+	defer func(mode string) { ScopeMode = mode }(ScopeMode)
+	ScopeMode = "prompt"
+	if got := scopeSection([]string{"api", "web"}); !strings.Contains(got, "type(api,web): description") {
+		t.Errorf("scopeSection() = %q, want the api,web scope", got)
+	}
+	if got := scopeSection([]string{"a", "b", "c", "d"}); !strings.Contains(got, "splitting the commit") {
+		t.Errorf("scopeSection() = %q, want a hint to split", got)
+	}
+	if got := scopeSection(nil); got != "" {
+		t.Errorf("scopeSection() = %q, want empty", got)
+	}
+	ScopeMode = "off"
+	if got := scopeSection([]string{"api"}); got != "" {
+		t.Errorf("scopeSection() = %q, want empty when off", got)
+	}
+}
+
+func Test_rewriteScope(t *testing.T) {
+	tests := []struct {
+		name   string
+		text   string
+		scopes []string
+		want   string
+	}{
+		{
+			name:   "replaces scope",
+			text:   "<thinkthrough>x</thinkthrough>\n<commit-message>\nfeat(server): add users\n\nBody (with parens): here.\n</commit-message>",
+			scopes: []string{"api"},
+			want:   "<thinkthrough>x</thinkthrough>\n<commit-message>\nfeat(api): add users\n\nBody (with parens): here.\n</commit-message>",
+		},
+		{
+			name:   "adds scope and keeps breaking marker",
+			text:   "<commit-message>fix!: drop v1</commit-message>",
+			scopes: []string{"api", "web"},
+			want:   "<commit-message>fix(api,web)!: drop v1</commit-message>",
+		},
+		{
+			name:   "not conventional",
+			text:   "<commit-message>\nAdd users\n</commit-message>",
+			scopes: []string{"api"},
+			want:   "<commit-message>\nAdd users\n</commit-message>",
+		},
+		{
+			name:   "too many scopes",
+			text:   "<commit-message>\nfeat: add users\n</commit-message>",
+			scopes: []string{"a", "b", "c", "d"},
+			want:   "<commit-message>\nfeat: add users\n</commit-message>",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if diff := cmp.Diff(tt.want, rewriteScope(tt.text, tt.scopes)); diff != "" {
+				t.Errorf("rewriteScope() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,308 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// bump is the part of a semantic version that a release increments.
+type bump int
+
+const (
+	bumpNone bump = iota
+	bumpPatch
+	bumpMinor
+	bumpMajor
+)
+
+func (b bump) String() string {
+	switch b {
+	case bumpPatch:
+		return "patch"
+	case bumpMinor:
+		return "minor"
+	case bumpMajor:
+		return "major"
+	}
+	return "none"
+}
+
+// semver is a semantic version, such as v1.2.3. Prefix is "v" or empty, and
+// Pre is the pre-release and build suffix, such as "-rc.1".
+type semver struct {
+	Prefix              string
+	Major, Minor, Patch int
+	Pre                 string
+}
+
+var semverPattern = regexp.MustCompile(`^(v?)(\d+)\.(\d+)\.(\d+)([-+].*)?$`)
+
+func parseSemver(s string) (semver, bool) {
+	m := semverPattern.FindStringSubmatch(s)
+	if m == nil {
+		return semver{}, false
+	}
+	v := semver{Prefix: m[1], Pre: m[5]}
+	v.Major, _ = strconv.Atoi(m[2])
+	v.Minor, _ = strconv.Atoi(m[3])
+	v.Patch, _ = strconv.Atoi(m[4])
+	return v, true
+}
+
+func (v semver) String() string {
+	return fmt.Sprintf("%s%d.%d.%d%s", v.Prefix, v.Major, v.Minor, v.Patch, v.Pre)
+}
+
+// next returns the version after v with the bump b. A pre-release of the
+// version, such as v2.0.0-rc.1, is released as is.
+func (v semver) next(b bump) semver {
+	next := semver{Prefix: v.Prefix, Major: v.Major, Minor: v.Minor, Patch: v.Patch}
+	if v.Pre != "" && b != bumpNone {
+		return next
+	}
+	switch b {
+	case bumpMajor:
+		next.Major, next.Minor, next.Patch = v.Major+1, 0, 0
+	case bumpMinor:
+		next.Minor, next.Patch = v.Minor+1, 0
+	case bumpPatch:
+		next.Patch++
+	default:
+		next.Pre = v.Pre
+	}
+	return next
+}
+
+// recommendBump works out the bump for commits from their conventional types
+// and breaking changes, and checks it against the changes to the Go API. It
+// explains each step in the reasons.
+func recommendBump(commits []conventionalCommit, api []goPackageChanges) (b bump, reasons []string) {
+	var other int
+	for _, c := range commits {
+		subject := c.Description
+		if c.Type != "" {
+			subject = c.Type + ": " + subject
+			if c.Scope != "" {
+				subject = fmt.Sprintf("%s(%s): %s", c.Type, c.Scope, c.Description)
+			}
+		}
+		hash := c.Hash
+		if len(hash) > 7 {
+			hash = hash[:7]
+		}
+		switch {
+		case c.Breaking:
+			b = max(b, bumpMajor)
+			reasons = append(reasons, fmt.Sprintf("major: %s %s is a breaking change", hash, subject))
+		case c.Type == "feat":
+			b = max(b, bumpMinor)
+			reasons = append(reasons, fmt.Sprintf("minor: %s %s adds a feature", hash, subject))
+		case c.Type == "fix" || c.Type == "perf":
+			b = max(b, bumpPatch)
+			reasons = append(reasons, fmt.Sprintf("patch: %s %s is a fix", hash, subject))
+		default:
+			other++
+		}
+	}
+	if other > 0 {
+		reasons = append(reasons, fmt.Sprintf("none: %d other commits, such as docs or chore, do not need a release", other))
+	}
+
+	fromCommits := b
+	for _, pkg := range api {
+		if !pkg.Public {
+			continue
+		}
+		for _, change := range pkg.Changes {
+			apiBump := bumpMinor
+			if change.Breaking {
+				apiBump = bumpMajor
+			} else if !strings.HasPrefix(change.Description, "added ") {
+				// Other changes, to bodies or compatible struct fields, are
+				// not visible in the API.
+				continue
+			}
+			if apiBump <= fromCommits {
+				continue
+			}
+			b = max(b, apiBump)
+			reasons = append(reasons, fmt.Sprintf("%s: the Go API of package %s (%s) %s, but no commit says so",
+				apiBump, pkg.Package, pkg.Dir, change.Description))
+		}
+	}
+	return b, reasons
+}
+
+// semverCommand recommends the next version from the commits since the last
+// tag, or in the range given, and offers to tag the end of it with it.
+func semverCommand(ctx context.Context, config gitConfig, args []string) int {
+	flags := flag.NewFlagSet("commitgpt semver", flag.ContinueOnError)
+	tag := flags.Bool("tag", false, "create the annotated tag without asking")
+	noTag := flags.Bool("no-tag", false, "do not offer to create the tag")
+	withStaged := flags.Bool("staged", false, "check the Go API of the staged changes too")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() > 1 {
+		fmt.Fprintf(os.Stderr, "commitgpt semver: unexpected argument %q\n", flags.Arg(1))
+		return 2
+	}
+	if *withStaged && (flags.NArg() > 0 || *tag) {
+		fmt.Fprintln(os.Stderr, "commitgpt semver: --staged cannot be used with a range or --tag")
+		return 2
+	}
+
+	from, to, err := semverRange(ctx, flags.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "commitgpt semver:", err)
+		return 2
+	}
+	next, err := runSemver(ctx, os.Stdout, from, to, *withStaged)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "commitgpt semver:", err)
+		return 1
+	}
+	// Staged changes are not committed yet, so there is nothing to tag.
+	if next == "" || *noTag || *withStaged {
+		return 0
+	}
+	if !*tag {
+		if stat, err := os.Stdin.Stat(); err != nil || stat.Mode()&os.ModeCharDevice == 0 {
+			return 0
+		}
+		if !confirm(os.Stdin, os.Stdout, fmt.Sprintf("Create the annotated tag %s on %s?", next, to)) {
+			return 0
+		}
+	}
+	if err := createReleaseTag(ctx, next, from, to); err != nil {
+		fmt.Fprintln(os.Stderr, "commitgpt semver:", err)
+		return 1
+	}
+	fmt.Printf("Tagged %s as %s.\n", to, next)
+	return 0
+}
+
+// semverRange returns the ends of rev, or the last tag and HEAD when rev is
+// empty. from is empty when there are no tags.
+func semverRange(ctx context.Context, rev string) (from, to string, err error) {
+	if rev == "" {
+		return lastTag(ctx, "HEAD"), "HEAD", nil
+	}
+	return parseRevRange(ctx, rev)
+}
+
+// runSemver writes the recommendation for the commits from..to to w and
+// returns the next version, or "" when no release is needed. The current
+// version is the last tag at from. With withStaged, the Go API of the staged
+// changes is checked as well.
+func runSemver(ctx context.Context, w io.Writer, from, to string, withStaged bool) (string, error) {
+	tag := ""
+	if from != "" {
+		tag = lastTag(ctx, from)
+	}
+	current := semver{Prefix: "v"}
+	if tag != "" {
+		var ok bool
+		if current, ok = parseSemver(tag); !ok {
+			return "", fmt.Errorf("the last tag %s is not a semantic version", tag)
+		}
+	}
+	commits, err := rangeCommits(ctx, revisionRange(from, to))
+	if err != nil {
+		return "", err
+	}
+	var ranges []revRange
+	if from != "" {
+		ranges = append(ranges, revRange{Base: from, Head: to})
+	}
+	if withStaged {
+		ranges = append(ranges, staged)
+	}
+	var api []goPackageChanges
+	for _, r := range ranges {
+		paths, err := rangePaths(ctx, r)
+		if err != nil {
+			return "", err
+		}
+		api = append(api, goAPIChanges(ctx, r, paths)...)
+	}
+
+	b, reasons := recommendBump(commits, api)
+	if b == bumpMajor && current.Major == 0 && current.Pre == "" {
+		b = bumpMinor
+		reasons = append(reasons, "minor: before 1.0.0, breaking changes bump the minor version")
+	}
+	if tag == "" {
+		fmt.Fprintf(w, "Current version: none, there are no tags\n")
+	} else {
+		fmt.Fprintf(w, "Current version: %s\n", current)
+	}
+	fmt.Fprintf(w, "Commits since: %d\n", len(commits))
+	next := current.next(b)
+	if b == bumpNone {
+		fmt.Fprintf(w, "Recommended: no release\n")
+	} else {
+		fmt.Fprintf(w, "Recommended: %s (%s)\n", next, b)
+	}
+	if len(reasons) > 0 {
+		fmt.Fprintf(w, "\nReasons:\n")
+		for _, reason := range reasons {
+			fmt.Fprintf(w, "- %s\n", reason)
+		}
+	}
+	if b == bumpNone {
+		return "", nil
+	}
+	return next.String(), nil
+}
+
+// createReleaseTag tags to as version, with the changelog of from..to and a
+// summary of it from the model as the message.
+func createReleaseTag(ctx context.Context, version, from, to string) error {
+	commits, err := rangeCommits(ctx, revisionRange(from, to))
+	if err != nil {
+		return err
+	}
+	rel := release{Version: version, Entries: groupChangelog(commits, false)}
+	if len(rel.Entries) > 0 {
+		if rel.Summary, err = summariseRelease(ctx, rel); err != nil {
+			return err
+		}
+	}
+	message := rel.String()
+	// The heading is the tag's subject line, without the Markdown.
+	_, body, _ := strings.Cut(message, "\n")
+	message = version + "\n" + strings.TrimRight(body, "\n") + "\n"
+
+	// The default cleanup would strip the section headings as comments.
+	cmd := exec.CommandContext(ctx, "git", "tag", "-a", "--cleanup=verbatim", "-F", "-", version, to)
+	cmd.Stdin = strings.NewReader(message)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("git tag: %v: %s", err, bytes.TrimSpace(stderr.Bytes()))
+	}
+	return nil
+}
+
+// confirm asks question on out and reports whether the answer read from in is
+// yes.
+func confirm(in io.Reader, out io.Writer, question string) bool {
+	fmt.Fprintf(out, "%s [y/N] ", question)
+	answer, err := bufio.NewReader(in).ReadString('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		return false
+	}
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os/exec"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func Test_semver_next(t *testing.T) {
+	tests := []struct {
+		version string
+		bump    bump
+		want    string
+	}{
+		{"v1.2.3", bumpMajor, "v2.0.0"},
+		{"v1.2.3", bumpMinor, "v1.3.0"},
+		{"1.2.3", bumpPatch, "1.2.4"},
+		{"v1.2.3", bumpNone, "v1.2.3"},
+		{"v2.0.0-rc.1", bumpPatch, "v2.0.0"},
+		{"v2.0.0-rc.1", bumpNone, "v2.0.0-rc.1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.version+" "+tt.bump.String(), func(t *testing.T) {
+			v, ok := parseSemver(tt.version)
+			if !ok {
+				t.Fatalf("parseSemver(%q) failed", tt.version)
+			}
+			if got := v.next(tt.bump).String(); got != tt.want {
+				t.Errorf("next() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+	for _, s := range []string{"release-1", "v1.2", "v1.2.3.4"} {
+		if _, ok := parseSemver(s); ok {
+			t.Errorf("parseSemver(%q) succeeded", s)
+		}
+	}
+}
+
+func Test_recommendBump(t *testing.T) {
+	tests := []struct {
+		name        string
+		commits     []conventionalCommit
+		api         []goPackageChanges
+		want        bump
+		wantReasons []string
+	}{
+		{
+			name:        "nothing",
+			want:        bumpNone,
+			wantReasons: nil,
+		},
+		{
+			name: "feature and fix",
+			commits: []conventionalCommit{
+				{Hash: "1111111aaa", Type: "fix", Description: "a"},
+				{Hash: "2222222bbb", Type: "feat", Scope: "api", Description: "b"},
+				{Hash: "3333333ccc", Type: "docs", Description: "c"},
+			},
+			want: bumpMinor,
+			wantReasons: []string{
+				"patch: 1111111 fix: a is a fix",
+				"minor: 2222222 feat(api): b adds a feature",
+				"none: 1 other commits, such as docs or chore, do not need a release",
+			},
+		},
+		{
+			name:        "breaking footer",
+			commits:     []conventionalCommit{{Hash: "1111111", Type: "chore", Description: "a", Breaking: true}},
+			want:        bumpMajor,
+			wantReasons: []string{"major: 1111111 chore: a is a breaking change"},
+		},
+		{
+			name:    "API breaks without a breaking commit",
+			commits: []conventionalCommit{{Hash: "1111111", Type: "fix", Description: "a"}},
+			api: []goPackageChanges{
+				{Dir: "internal", Package: "x", Changes: []goAPIChange{{Description: "added func New()"}}},
+				{Dir: "a", Package: "a", Public: true, Changes: []goAPIChange{
+					{Description: "modified body of func F"},
+					{Description: "removed func Old()", Breaking: true},
+				}},
+			},
+			want: bumpMajor,
+			wantReasons: []string{
+				"patch: 1111111 fix: a is a fix",
+				"major: the Go API of package a (a) removed func Old(), but no commit says so",
+			},
+		},
+		{
+			name:    "API addition matches the commits",
+			commits: []conventionalCommit{{Hash: "1111111", Type: "feat", Description: "a"}},
+			api: []goPackageChanges{{Dir: ".", Package: "a", Public: true, Changes: []goAPIChange{
+				{Description: "added func New()"},
+			}}},
+			want:        bumpMinor,
+			wantReasons: []string{"minor: 1111111 feat: a adds a feature"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, reasons := recommendBump(tt.commits, tt.api)
+			if got != tt.want {
+				t.Errorf("recommendBump() = %s, want %s", got, tt.want)
+			}
+			if diff := cmp.Diff(tt.wantReasons, reasons); diff != "" {
+				t.Errorf("recommendBump() reasons mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func Test_runSemver(t *testing.T) {
+	prompt := fakeAPI(t, "<summary>\nAdds New.\n</summary>")
+	ctx := context.Background()
+	chdirTempRepo(t)
+	stageFiles(t, map[string]string{"go.mod": "module example.com/a\n", "a/a.go": "package a\n\nfunc Old() {}\n"})
+	commitStaged(t, "feat: a")
+
+	var out bytes.Buffer
+	next, err := runSemver(ctx, &out, "", "HEAD", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next != "v0.1.0" || !strings.Contains(out.String(), "Current version: none") {
+		t.Errorf("runSemver() = %q, output:\n%s", next, out.String())
+	}
+
+	exec.Command("git", "tag", "v1.0.0").Run()
+	stageFiles(t, map[string]string{"a/a.go": "package a\n\nfunc New() {}\n"})
+	commitStaged(t, "fix(a): rename Old")
+	out.Reset()
+	next, err = runSemver(ctx, &out, "v1.0.0", "HEAD", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next != "v2.0.0" {
+		t.Errorf("runSemver() = %q, want v2.0.0", next)
+	}
+	for _, want := range []string{
+		"Current version: v1.0.0\n",
+		"Recommended: v2.0.0 (major)\n",
+		"- major: the Go API of package a (a) removed func Old(), but no commit says so\n",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("runSemver() output missing %q:\n%s", want, out.String())
+		}
+	}
+
+	t.Setenv("GIT_COMMITTER_NAME", "a")
+	t.Setenv("GIT_COMMITTER_EMAIL", "a@example.com")
+	if err := createReleaseTag(ctx, next, "v1.0.0", "HEAD"); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(*prompt, "- **a:** rename Old") {
+		t.Errorf("prompt = %q, want the changelog", *prompt)
+	}
+	message, err := git(ctx, "tag", "-l", "--format=%(contents)", "v2.0.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(message); !strings.HasPrefix(got, "v2.0.0\n\nAdds New.\n\n### Fixed\n\n- **a:** rename Old (") {
+		t.Errorf("tag message = %q", got)
+	}
+	out.Reset()
+	if next, err = runSemver(ctx, &out, "v2.0.0", "HEAD", false); err != nil || next != "" {
+		t.Errorf("runSemver() = %q, %v; want no release after tagging", next, err)
+	}
+}
+
+func Test_runSemver_movedDeclaration(t *testing.T) {
+	ctx := context.Background()
+	chdirTempRepo(t)
+	stageFiles(t, map[string]string{"go.mod": "module example.com/a\n", "a/a.go": "package a\n\nfunc Old() {}\n\nfunc Keep() {}\n"})
+	commitStaged(t, "feat: a")
+	exec.Command("git", "tag", "v1.0.0").Run()
+
+	stageFiles(t, map[string]string{"a/a.go": "package a\n\nfunc Keep() {}\n", "a/old.go": "package a\n\nfunc Old() {}\n"})
+	commitStaged(t, "refactor(a): move Old to its own file")
+	stageFiles(t, map[string]string{"a/a.go": "package a\n\nfunc Keep() { _ = 1 }\n"})
+	commitStaged(t, "fix(a): keep")
+
+	var out bytes.Buffer
+	next, err := runSemver(ctx, &out, "v1.0.0", "HEAD", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next != "v1.0.1" || strings.Contains(out.String(), "Go API") {
+		t.Errorf("runSemver() = %q, want v1.0.1 without API changes, output:\n%s", next, out.String())
+	}
+}
+
+func Test_semverRange(t *testing.T) {
+	ctx := context.Background()
+	chdirTempRepo(t)
+	stageFiles(t, map[string]string{"a": "a\n"})
+	commitStaged(t, "feat: a")
+	exec.Command("git", "tag", "v1.0.0").Run()
+	stageFiles(t, map[string]string{"a": "b\n"})
+	commitStaged(t, "fix: b")
+
+	tests := []struct {
+		rev      string
+		from, to string
+		err      bool
+	}{
+		{rev: "", from: "v1.0.0", to: "HEAD"},
+		{rev: "v0.9.0..v1.0.0", from: "v0.9.0", to: "v1.0.0"},
+		{rev: "v1.0.0..", from: "v1.0.0", to: "HEAD"},
+		{rev: "v1.0.0", err: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.rev, func(t *testing.T) {
+			from, to, err := semverRange(ctx, tt.rev)
+			if (err != nil) != tt.err || from != tt.from || to != tt.to {
+				t.Errorf("semverRange(%q) = %q, %q, %v, want %q, %q", tt.rev, from, to, err, tt.from, tt.to)
+			}
+		})
+	}
+}
+
+func Test_runSemver_range(t *testing.T) {
+	ctx := context.Background()
+	chdirTempRepo(t)
+	stageFiles(t, map[string]string{"a": "a\n"})
+	commitStaged(t, "feat: a")
+	exec.Command("git", "tag", "v1.0.0").Run()
+	stageFiles(t, map[string]string{"a": "b\n"})
+	commitStaged(t, "fix: b")
+	stageFiles(t, map[string]string{"c": "c\n"})
+	commitStaged(t, "feat!: c")
+
+	var out bytes.Buffer
+	next, err := runSemver(ctx, &out, "v1.0.0", "HEAD~1", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next != "v1.0.1" || !strings.Contains(out.String(), "Commits since: 1\n") {
+		t.Errorf("runSemver() = %q, want v1.0.1 from one commit, output:\n%s", next, out.String())
+	}
+}
+
+func Test_runSemver_staged(t *testing.T) {
+	ctx := context.Background()
+	chdirTempRepo(t)
+	stageFiles(t, map[string]string{"go.mod": "module example.com/a\n", "a/a.go": "package a\n\nfunc Old() {}\n"})
+	commitStaged(t, "feat: a")
+	exec.Command("git", "tag", "v1.0.0").Run()
+	stageFiles(t, map[string]string{"a/a.go": "package a\n\nfunc New() {}\n"})
+
+	var out bytes.Buffer
+	if next, err := runSemver(ctx, &out, "v1.0.0", "HEAD", false); err != nil || next != "" {
+		t.Errorf("runSemver() = %q, %v, want no release without the staged changes", next, err)
+	}
+	out.Reset()
+	next, err := runSemver(ctx, &out, "v1.0.0", "HEAD", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "- major: the Go API of package a (a) removed func Old(), but no commit says so\n"
+	if next != "v2.0.0" || !strings.Contains(out.String(), want) {
+		t.Errorf("runSemver() = %q, want v2.0.0 with %q, output:\n%s", next, want, out.String())
+	}
+}
+
+func Test_confirm(t *testing.T) {
+	for answer, want := range map[string]bool{"y\n": true, "Yes\n": true, "n\n": false, "\n": false, "": false} {
+		var out bytes.Buffer
+		if got := confirm(strings.NewReader(answer), &out, "Tag?"); got != want {
+			t.Errorf("confirm(%q) = %v, want %v", answer, got, want)
+		}
+		if out.String() != "Tag? [y/N] " {
+			t.Errorf("confirm() asked %q", out.String())
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// BlockSensitive makes the hook fail, rather than warn, when the model finds
+// sensitive information in the diff.
+var BlockSensitive bool
+
+// allowSensitive reports whether sensitive information findings have been
+// overridden by COMMITGPT_ALLOW_SENSITIVE, and describes the override. A
+// trailer in the message cannot override them: git runs the hooks before the
+// message is written, and not at all for git commit -m.
+func allowSensitive() (string, bool) {
+	if v, err := strconv.ParseBool(os.Getenv("COMMITGPT_ALLOW_SENSITIVE")); err == nil && v {
+		return "COMMITGPT_ALLOW_SENSITIVE", true
+	}
+	return "", false
+}
+
+// checkSensitive fails if the model found sensitive information and it has
+// not been allowed. Allowed findings are recorded in the audit log.
+func checkSensitive(ctx context.Context, warning string) error {
+	if len(warningFindings(warning)) == 0 {
+		return nil
+	}
+	override, ok := allowSensitive()
+	if !ok {
+		return &stageError{Stage: stageSensitive, Err: fmt.Errorf(
+			"sensitive information found (set COMMITGPT_ALLOW_SENSITIVE=1 to commit anyway):\n%s", warning)}
+	}
+	fmt.Fprintf(os.Stderr, "commitgpt: sensitive information allowed by %s\n", override)
+	if err := auditSensitive(ctx, override, warning); err != nil {
+		fmt.Fprintln(os.Stderr, "commitgpt: audit:", err)
+	}
+	return nil
+}
+
+// auditEntry is a line of the audit log.
+type auditEntry struct {
+	Time     time.Time `json:"time"`
+	Tree     string    `json:"tree,omitempty"`
+	User     string    `json:"user,omitempty"`
+	Override string    `json:"override"`
+	Finding  string    `json:"finding"`
+}
+
+// auditSensitive appends an overridden finding to audit.log in
+// ANTHROPIC_LOG_DIR.
+func auditSensitive(ctx context.Context, override, finding string) error {
+	logDir := os.Getenv("ANTHROPIC_LOG_DIR")
+	if logDir == "" {
+		return fmt.Errorf("not recorded, ANTHROPIC_LOG_DIR is not set")
+	}
+	entry := auditEntry{
+		Time:     time.Now().UTC(),
+		Override: override,
+		Finding:  finding,
+	}
+	if tree, err := git(ctx, "write-tree"); err == nil {
+		entry.Tree = strings.TrimSpace(string(tree))
+	}
+	if user, err := git(ctx, "config", "user.email"); err == nil {
+		entry.User = strings.TrimSpace(string(user))
+	}
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(logDir, 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(filepath.Join(logDir, "audit.log"), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// preCommitCommand asks the model to review the staged changes and fails if it
+// finds sensitive information. It is meant to be run as a pre-commit hook.
+// With --sarif, the findings are also written to a SARIF file.
+func preCommitCommand(ctx context.Context, config gitConfig, args []string) int {
+	flags := flag.NewFlagSet("commitgpt pre-commit", flag.ContinueOnError)
+	sarif := flags.String("sarif", "", "write the sensitive information and large file findings to `file` as SARIF")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() > 0 {
+		fmt.Fprintf(os.Stderr, "commitgpt pre-commit: unexpected argument %q\n", flags.Arg(0))
+		return 2
+	}
+
+	branch, err := git(ctx, "rev-parse", "--abbrev-ref", "HEAD")
+	if err != nil {
+		return preCommitFailure(&stageError{Stage: stageGit, Err: err})
+	}
+	diff, err := stagedDiff(ctx)
+	if err != nil {
+		return preCommitFailure(&stageError{Stage: stageGit, Err: err})
+	}
+	gen, err := generate(ctx, string(branch), diff)
+	if err != nil {
+		return preCommitFailure(err)
+	}
+	sensitiveWarn, largeFilesWarn, _, _ := extractMessages(gen.Response.Text())
+	if *sarif != "" {
+		if err := writeSARIFFile(*sarif, diff, sensitiveWarn, largeFilesWarn); err != nil {
+			return preCommitFailure(&stageError{Stage: stageLog, Err: err})
+		}
+	}
+	if err := archiveSARIF(ctx, diff, sensitiveWarn, largeFilesWarn); err != nil {
+		return preCommitFailure(&stageError{Stage: stageLog, Err: err})
+	}
+	return preCommitFailure(checkSensitive(ctx, sensitiveWarn))
+}
+
+// preCommitFailure applies the failure policy for err. There is no commit
+// message to comment on yet, so the comment policy continues.
+func preCommitFailure(err error) int {
+	if err == nil {
+		return 0
+	}
+	var se *stageError
+	if !errors.As(err, &se) {
+		fmt.Fprintln(os.Stderr, "commitgpt:", err)
+		return 1
+	}
+	fmt.Fprintf(os.Stderr, "commitgpt: %s failed: %v\n", se.Stage, se.Err)
+	if FailurePolicies[se.Stage] == policyAbort {
+		return 1
+	}
+	return 0
+}
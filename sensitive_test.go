@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func Test_allowSensitive(t *testing.T) {
+	tests := []struct {
+		name   string
+		env    string
+		want   string
+		wantOK bool
+	}{
+		{
+			name: "none",
+		},
+		{
+			name:   "env",
+			env:    "1",
+			want:   "COMMITGPT_ALLOW_SENSITIVE",
+			wantOK: true,
+		},
+		{
+			name: "env false",
+			env:  "false",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("COMMITGPT_ALLOW_SENSITIVE", tt.env)
+			got, ok := allowSensitive()
+			if got != tt.want || ok != tt.wantOK {
+				t.Errorf("allowSensitive() = %q, %v, want %q, %v", got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func Test_checkSensitive(t *testing.T) {
+	chdirTempRepo(t)
+	logDir := filepath.Join(t.TempDir(), "logs")
+	t.Setenv("ANTHROPIC_LOG_DIR", logDir)
+	t.Setenv("COMMITGPT_ALLOW_SENSITIVE", "")
+	ctx := context.Background()
+	finding := "Warning: The diff contains sensitive information:\n- config.yaml, line 3: An AWS key"
+
+	if err := checkSensitive(ctx, ""); err != nil {
+		t.Errorf("checkSensitive() with no finding = %v", err)
+	}
+	if err := checkSensitive(ctx, "No sensitive information was found."); err != nil {
+		t.Errorf("checkSensitive() with no listed finding = %v", err)
+	}
+
+	err := checkSensitive(ctx, finding)
+	var se *stageError
+	if !errors.As(err, &se) || se.Stage != stageSensitive || !strings.Contains(err.Error(), "config.yaml") {
+		t.Errorf("checkSensitive() err = %v, want %s stage with the finding", err, stageSensitive)
+	}
+	if _, err := os.Stat(filepath.Join(logDir, "audit.log")); !os.IsNotExist(err) {
+		t.Errorf("blocked finding was audited: %v", err)
+	}
+
+	t.Setenv("COMMITGPT_ALLOW_SENSITIVE", "1")
+	if err := checkSensitive(ctx, finding); err != nil {
+		t.Fatalf("checkSensitive() with override = %v", err)
+	}
+	data, err := os.ReadFile(filepath.Join(logDir, "audit.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var entry auditEntry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		t.Fatalf("audit.log: %v: %s", err, data)
+	}
+	if entry.Override != "COMMITGPT_ALLOW_SENSITIVE" || entry.Finding != finding || entry.Tree == "" {
+		t.Errorf("audit entry = %+v", entry)
+	}
+}
+
+func Test_preCommitFailure(t *testing.T) {
+	resetFailurePolicies(t)
+	FailurePolicies[stageCredentials] = policyComment
+
+	tests := []struct {
+		name string
+		err  error
+		want int
+	}{
+		{"success", nil, 0},
+		{"sensitive", &stageError{Stage: stageSensitive, Err: errors.New("found")}, 1},
+		{"comment continues", &stageError{Stage: stageCredentials, Err: errNoAPIKey}, 0},
+		{"no stage", errors.New("boom"), 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := preCommitFailure(tt.err); got != tt.want {
+				t.Errorf("preCommitFailure() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,404 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+//go:embed split-prompt.txt
+var splitPromptData string
+
+// SplitAdvice asks the model, in a second request, whether the staged changes
+// should be split into several commits.
+var SplitAdvice = false
+
+// diffFile is the diff of one file: its header, and its hunks. Files without
+// hunks, such as binary files, are all header.
+type diffFile struct {
+	Path   string
+	Header string
+	Hunks  []string
+}
+
+// hunkRef is a hunk of a file, or the whole file when Hunk is -1.
+type hunkRef struct {
+	File, Hunk int
+}
+
+// parsePatch splits the output of git diff into files and hunks.
+func parsePatch(patch string) []diffFile {
+	var files []diffFile
+	for _, line := range strings.SplitAfter(patch, "\n") {
+		switch {
+		case strings.HasPrefix(line, "diff --git "):
+			files = append(files, diffFile{Path: diffPath(line), Header: line})
+		case len(files) == 0 || line == "":
+		case strings.HasPrefix(line, "@@ "):
+			f := &files[len(files)-1]
+			f.Hunks = append(f.Hunks, line)
+		default:
+			f := &files[len(files)-1]
+			if len(f.Hunks) == 0 {
+				f.Header += line
+				if p := strings.TrimPrefix(strings.TrimSpace(line), "+++ b/"); p != strings.TrimSpace(line) {
+					f.Path = p
+				}
+			} else {
+				f.Hunks[len(f.Hunks)-1] += line
+			}
+		}
+	}
+	return files
+}
+
+// diffPath returns the new path in a diff --git line, which is only
+// ambiguous when the path contains " b/".
+func diffPath(line string) string {
+	line = strings.TrimSpace(strings.TrimPrefix(line, "diff --git "))
+	if i := strings.LastIndex(line, " b/"); i >= 0 {
+		return line[i+3:]
+	}
+	return line
+}
+
+// hunkRefs numbers the hunks of files, from 1.
+func hunkRefs(files []diffFile) []hunkRef {
+	var refs []hunkRef
+	for i, f := range files {
+		if len(f.Hunks) == 0 {
+			refs = append(refs, hunkRef{File: i, Hunk: -1})
+		}
+		for j := range f.Hunks {
+			refs = append(refs, hunkRef{File: i, Hunk: j})
+		}
+	}
+	return refs
+}
+
+// describeHunk is how a hunk is shown in the plan, by its file and hunk
+// header.
+func describeHunk(files []diffFile, ref hunkRef) string {
+	f := files[ref.File]
+	if ref.Hunk < 0 {
+		return f.Path
+	}
+	header, _, _ := strings.Cut(f.Hunks[ref.Hunk], "\n")
+	if i := strings.Index(header[2:], "@@"); i >= 0 {
+		header = header[:i+4]
+	}
+	return f.Path + " " + header
+}
+
+// buildPatch joins the hunks of refs into a patch that git apply accepts.
+func buildPatch(files []diffFile, refs []hunkRef) string {
+	selected := map[int][]int{}
+	for _, ref := range refs {
+		selected[ref.File] = append(selected[ref.File], ref.Hunk)
+	}
+	var patch strings.Builder
+	for i, f := range files {
+		hunks, ok := selected[i]
+		if !ok {
+			continue
+		}
+		sort.Ints(hunks)
+		patch.WriteString(f.Header)
+		if hunks[0] < 0 {
+			// The whole file.
+			hunks = hunks[:0]
+			for j := range f.Hunks {
+				hunks = append(hunks, j)
+			}
+		}
+		for _, j := range hunks {
+			patch.WriteString(f.Hunks[j])
+		}
+	}
+	return patch.String()
+}
+
+// splitGroup is one of the commits a split proposes.
+type splitGroup struct {
+	Subject string   `json:"subject"`
+	Hunks   []string `json:"hunks"`
+	Patch   string   `json:"patch"`
+}
+
+// splitPlan is saved by the hook for commitgpt split to apply. Tree is the
+// staged tree it was made for, and Next the group to stage next.
+type splitPlan struct {
+	Tree   string       `json:"tree"`
+	Next   int          `json:"next"`
+	Groups []splitGroup `json:"groups"`
+}
+
+var groupPattern = regexp.MustCompile(`<group subject="([^"]*)">([\d\s,]*)</group>`)
+
+// parseSplitGroups reads the groups of hunk numbers in the model's answer. It
+// returns nil unless every one of n hunks is in exactly one group.
+func parseSplitGroups(text string, n int) (subjects []string, groups [][]int) {
+	seen := make([]bool, n+1)
+	for _, m := range groupPattern.FindAllStringSubmatch(text, -1) {
+		var group []int
+		for _, field := range strings.FieldsFunc(m[2], func(r rune) bool { return r == ' ' || r == ',' || r == '\n' || r == '\t' }) {
+			i, err := strconv.Atoi(field)
+			if err != nil || i < 1 || i > n || seen[i] {
+				return nil, nil
+			}
+			seen[i] = true
+			group = append(group, i)
+		}
+		if len(group) > 0 {
+			subjects = append(subjects, strings.TrimSpace(m[1]))
+			groups = append(groups, group)
+		}
+	}
+	for i := 1; i <= n; i++ {
+		if !seen[i] {
+			return nil, nil
+		}
+	}
+	return subjects, groups
+}
+
+// splitRefs picks the hunks of files to show the model, following the modes
+// of their paths. Summarised files are shown whole, without their diff.
+// Excluded files are hidden, and committed with the first group.
+func splitRefs(files []diffFile, modes map[string]pathMode) (shown, hidden []hunkRef) {
+	for i, f := range files {
+		switch modes[f.Path] {
+		case pathInclude:
+			if len(f.Hunks) == 0 {
+				shown = append(shown, hunkRef{File: i, Hunk: -1})
+			}
+			for j := range f.Hunks {
+				shown = append(shown, hunkRef{File: i, Hunk: j})
+			}
+		case pathExclude:
+			hidden = append(hidden, hunkRef{File: i, Hunk: -1})
+		default:
+			shown = append(shown, hunkRef{File: i, Hunk: -1})
+		}
+	}
+	return shown, hidden
+}
+
+// adviseSplit asks the model how the staged changes group into logical
+// changes. It returns nil when they are one change. The files left out of the
+// diff for the commit message are left out of the advice too.
+func adviseSplit(ctx context.Context) (*splitPlan, error) {
+	paths, err := stagedPaths(ctx)
+	if err != nil {
+		return nil, err
+	}
+	modes, err := pathModes(ctx, staged, paths)
+	if err != nil {
+		return nil, err
+	}
+	// The patches must apply with git apply whatever diff.noprefix,
+	// diff.mnemonicPrefix and diff.renames say, and each file must have the
+	// path that its mode is found by.
+	patch, err := git(ctx, "diff", "--cached", "--binary", "--no-color", "--no-ext-diff", "--no-renames",
+		"--src-prefix=a/", "--dst-prefix=b/")
+	if err != nil {
+		return nil, err
+	}
+	files := parsePatch(string(patch))
+	refs, hidden := splitRefs(files, modes)
+	if len(refs) < 2 {
+		return nil, nil
+	}
+
+	var hunks strings.Builder
+	for i, ref := range refs {
+		f := files[ref.File]
+		fmt.Fprintf(&hunks, "<hunk id=\"%d\" file=%q>\n", i+1, f.Path)
+		switch {
+		case ref.Hunk >= 0:
+			hunks.WriteString(f.Hunks[ref.Hunk])
+		case len(f.Hunks) > 0:
+			hunks.WriteString("(summarised, diff not shown)\n")
+		default:
+			hunks.WriteString("(binary or metadata change)\n")
+		}
+		hunks.WriteString("</hunk>\n")
+	}
+	prompt := redactPrompt(hunks.String())
+	content := fmt.Sprintf(splitPromptData, prompt.Parts[0])
+	gen, err := prompt.ask(ctx, func(model modelSpec) (messageResponse, error) {
+		return sendPrompt(ctx, model, content, false)
+	})
+	if err != nil {
+		return nil, err
+	}
+	subjects, groups := parseSplitGroups(gen.Response.Text(), len(refs))
+	if len(groups) < 2 {
+		return nil, nil
+	}
+
+	tree, err := git(ctx, "write-tree")
+	if err != nil {
+		return nil, err
+	}
+	plan := &splitPlan{Tree: strings.TrimSpace(string(tree))}
+	for i, group := range groups {
+		var groupRefs []hunkRef
+		g := splitGroup{Subject: subjects[i]}
+		if i == 0 {
+			for _, ref := range hidden {
+				groupRefs = append(groupRefs, ref)
+				g.Hunks = append(g.Hunks, describeHunk(files, ref))
+			}
+		}
+		for _, n := range group {
+			groupRefs = append(groupRefs, refs[n-1])
+			g.Hunks = append(g.Hunks, describeHunk(files, refs[n-1]))
+		}
+		g.Patch = buildPatch(files, groupRefs)
+		plan.Groups = append(plan.Groups, g)
+	}
+	return plan, nil
+}
+
+// comment describes the plan in comment lines for the commit message file.
+func (p *splitPlan) comment() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "commitgpt: these changes look like %d separate commits:\n\n", len(p.Groups))
+	for i, g := range p.Groups {
+		fmt.Fprintf(&b, "  %d. %s\n", i+1, g.Subject)
+		for _, hunk := range g.Hunks {
+			fmt.Fprintf(&b, "       %s\n", hunk)
+		}
+	}
+	b.WriteString("\n")
+	b.WriteString("To commit them separately, abort this commit by deleting the message\n")
+	b.WriteString("above, then run commitgpt split and commit, once for each group.\n")
+	return commentLines(b.String())
+}
+
+// splitPlanPath is where the plan is kept, in the git directory.
+func splitPlanPath(ctx context.Context) (string, error) {
+	out, err := git(ctx, "rev-parse", "--git-path", "commitgpt-split.json")
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+func saveSplitPlan(ctx context.Context, plan *splitPlan) error {
+	file, err := splitPlanPath(ctx)
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(plan, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(file, data, 0644)
+}
+
+func loadSplitPlan(ctx context.Context) (*splitPlan, string, error) {
+	file, err := splitPlanPath(ctx)
+	if err != nil {
+		return nil, "", err
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, file, err
+	}
+	var plan splitPlan
+	if err := json.Unmarshal(data, &plan); err != nil {
+		return nil, file, fmt.Errorf("%s: %w", file, err)
+	}
+	return &plan, file, nil
+}
+
+// splitAdviceComment asks for split advice in the hook and saves the plan.
+// It returns the plan as comment lines for the commit message, or nothing
+// when the changes are one commit or a split is already under way. Failing
+// to advise does not fail the hook.
+func splitAdviceComment(ctx context.Context) string {
+	if plan, _, err := loadSplitPlan(ctx); err == nil && plan.Next > 0 {
+		return ""
+	}
+	plan, err := adviseSplit(ctx)
+	if err == nil && plan != nil {
+		err = saveSplitPlan(ctx, plan)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "commitgpt: split advice:", err)
+		return ""
+	}
+	if plan == nil {
+		return ""
+	}
+	return plan.comment()
+}
+
+// splitCommand stages the next group of the plan made by the hook. The first
+// time, it unstages everything else.
+func splitCommand(ctx context.Context, config gitConfig, args []string) int {
+	if err := runSplit(ctx, args); err != nil {
+		fmt.Fprintln(os.Stderr, "commitgpt split:", err)
+		return 1
+	}
+	return 0
+}
+
+func runSplit(ctx context.Context, args []string) error {
+	plan, file, err := loadSplitPlan(ctx)
+	if errors.Is(err, os.ErrNotExist) {
+		return errors.New("no split is planned; set commitgpt.splitAdvice and commit to plan one")
+	}
+	if err != nil {
+		return err
+	}
+	if len(args) > 0 && args[0] == "--abort" {
+		return os.Remove(file)
+	}
+	if len(args) > 0 {
+		return fmt.Errorf("unknown argument %q", args[0])
+	}
+
+	if plan.Next == 0 {
+		tree, err := git(ctx, "write-tree")
+		if err != nil {
+			return err
+		}
+		if strings.TrimSpace(string(tree)) != plan.Tree {
+			return errors.New("the staged changes have changed since the split was planned; run commitgpt split --abort and commit again")
+		}
+		if _, err := git(ctx, "reset", "-q"); err != nil {
+			return err
+		}
+	} else if _, err := git(ctx, "diff", "--cached", "--quiet"); err != nil {
+		return fmt.Errorf("commit the staged group %d first", plan.Next)
+	}
+
+	group := plan.Groups[plan.Next]
+	apply := exec.CommandContext(ctx, "git", "apply", "--cached")
+	apply.Stdin = strings.NewReader(group.Patch)
+	var stderr bytes.Buffer
+	apply.Stderr = &stderr
+	if err := apply.Run(); err != nil {
+		return fmt.Errorf("git apply: %v: %s", err, bytes.TrimSpace(stderr.Bytes()))
+	}
+	plan.Next++
+	fmt.Printf("Staged %d of %d: %s\n", plan.Next, len(plan.Groups), group.Subject)
+	if plan.Next == len(plan.Groups) {
+		fmt.Println("This is the last group. Commit it to finish the split.")
+		return os.Remove(file)
+	}
+	fmt.Println("Commit it, then run commitgpt split again.")
+	return saveSplitPlan(ctx, plan)
+}
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+const splitTestPatch = `diff --git a/a.go b/a.go
+index 1111111..2222222 100644
+--- a/a.go
++++ b/a.go
+@@ -1,3 +1,3 @@ package a
+-x
++y
+ z
+@@ -10,2 +10,2 @@ func f() {
+-p
++q
+diff --git a/img.png b/img.png
+new file mode 100644
+index 0000000..3333333
+Binary files /dev/null and b/img.png differ
+`
+
+func Test_parsePatch(t *testing.T) {
+	files := parsePatch(splitTestPatch)
+	if len(files) != 2 {
+		t.Fatalf("parsePatch() = %d files, want 2", len(files))
+	}
+	if files[0].Path != "a.go" || len(files[0].Hunks) != 2 || files[1].Path != "img.png" || len(files[1].Hunks) != 0 {
+		t.Errorf("parsePatch() = %+v", files)
+	}
+	refs := hunkRefs(files)
+	want := []hunkRef{{0, 0}, {0, 1}, {1, -1}}
+	if diff := cmp.Diff(want, refs); diff != "" {
+		t.Errorf("hunkRefs() mismatch (-want +got):\n%s", diff)
+	}
+	if got := describeHunk(files, refs[1]); got != "a.go @@ -10,2 +10,2 @@" {
+		t.Errorf("describeHunk() = %q", got)
+	}
+	if got := buildPatch(files, []hunkRef{refs[2], refs[1]}); !strings.HasPrefix(got, "diff --git a/a.go b/a.go\n") ||
+		strings.Contains(got, "-x\n") || !strings.Contains(got, "-p\n") || !strings.HasSuffix(got, "differ\n") {
+		t.Errorf("buildPatch() = %q", got)
+	}
+	if got := buildPatch(files, refs); got != splitTestPatch {
+		t.Errorf("buildPatch() of every hunk = %q, want the patch", got)
+	}
+}
+
+func Test_parseSplitGroups(t *testing.T) {
+	tests := []struct {
+		name         string
+		text         string
+		n            int
+		wantSubjects []string
+		wantGroups   [][]int
+	}{
+		{
+			name:         "two groups",
+			text:         "<group subject=\"fix: a\">1 3</group>\n<group subject=\"style: b\">2</group>",
+			n:            3,
+			wantSubjects: []string{"fix: a", "style: b"},
+			wantGroups:   [][]int{{1, 3}, {2}},
+		},
+		{
+			name:         "commas",
+			text:         `<group subject="fix: a">1, 2</group>`,
+			n:            2,
+			wantSubjects: []string{"fix: a"},
+			wantGroups:   [][]int{{1, 2}},
+		},
+		{name: "missing hunk", text: `<group subject="fix: a">1</group>`, n: 2},
+		{name: "repeated hunk", text: `<group subject="a">1 2</group><group subject="b">2</group>`, n: 2},
+		{name: "unknown hunk", text: `<group subject="a">1 2 3</group>`, n: 2},
+		{name: "no groups", text: "They are one change.", n: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			subjects, groups := parseSplitGroups(tt.text, tt.n)
+			if diff := cmp.Diff(tt.wantSubjects, subjects); diff != "" {
+				t.Errorf("parseSplitGroups() subjects mismatch (-want +got):\n%s", diff)
+			}
+			if diff := cmp.Diff(tt.wantGroups, groups); diff != "" {
+				t.Errorf("parseSplitGroups() groups mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func Test_runSplit(t *testing.T) {
+	ctx := context.Background()
+	chdirTempRepo(t)
+	stageFiles(t, map[string]string{"a.txt": "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n"})
+	commitStaged(t, "feat: a")
+	stageFiles(t, map[string]string{
+		"a.txt": "one\n2\n3\n4\n5\n6\n7\n8\n9\nten\n",
+		"b.txt": "b\n",
+	})
+
+	if err := runSplit(ctx, nil); err == nil || !strings.Contains(err.Error(), "no split is planned") {
+		t.Fatalf("runSplit() err = %v, want no split planned", err)
+	}
+
+	patch, err := git(ctx, "diff", "--cached", "--binary")
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := parsePatch(string(patch))
+	refs := hunkRefs(files)
+	if len(refs) != 3 {
+		t.Fatalf("hunkRefs() = %v, want 3 hunks", refs)
+	}
+	tree, _ := git(ctx, "write-tree")
+	plan := &splitPlan{Tree: strings.TrimSpace(string(tree)), Groups: []splitGroup{
+		{Subject: "fix: one", Patch: buildPatch(files, []hunkRef{refs[0], refs[2]})},
+		{Subject: "fix: ten", Patch: buildPatch(files, []hunkRef{refs[1]})},
+	}}
+	if err := saveSplitPlan(ctx, plan); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := runSplit(ctx, nil); err != nil {
+		t.Fatalf("runSplit() err = %v", err)
+	}
+	staged, _ := git(ctx, "diff", "--cached", "--name-only")
+	if got := string(staged); got != "a.txt\nb.txt\n" {
+		t.Errorf("staged after first split = %q", got)
+	}
+	if err := runSplit(ctx, nil); err == nil || !strings.Contains(err.Error(), "commit the staged group 1") {
+		t.Errorf("runSplit() err = %v, want commit first", err)
+	}
+	commitStaged(t, "fix: one")
+
+	if err := runSplit(ctx, nil); err != nil {
+		t.Fatalf("runSplit() err = %v", err)
+	}
+	commitStaged(t, "fix: ten")
+	if out, err := exec.Command("git", "diff", "--quiet", "HEAD").CombinedOutput(); err != nil {
+		t.Errorf("the work tree differs from the last commit: %s", out)
+	}
+	if file, _ := splitPlanPath(ctx); fileExists(file) {
+		t.Errorf("the plan %s is left after the last group", file)
+	}
+}
+
+func Test_runSplit_changedIndex(t *testing.T) {
+	ctx := context.Background()
+	chdirTempRepo(t)
+	stageFiles(t, map[string]string{"a.txt": "a\n"})
+	commitStaged(t, "feat: a")
+	if err := saveSplitPlan(ctx, &splitPlan{Tree: "0000", Groups: []splitGroup{{}, {}}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := runSplit(ctx, nil); err == nil || !strings.Contains(err.Error(), "changed since") {
+		t.Errorf("runSplit() err = %v, want changed since", err)
+	}
+	if err := runSplit(ctx, []string{"--abort"}); err != nil {
+		t.Errorf("runSplit(--abort) err = %v", err)
+	}
+	if file, _ := splitPlanPath(ctx); fileExists(file) {
+		t.Errorf("the plan %s is left after --abort", file)
+	}
+}
+
+func Test_adviseSplit(t *testing.T) {
+	prompt := fakeAPI(t, `<group subject="docs: add b">2</group><group subject="fix: change a">1</group>`)
+	ctx := context.Background()
+	chdirTempRepo(t)
+	stageFiles(t, map[string]string{"a.txt": "a\n"})
+	commitStaged(t, "feat: a")
+	stageFiles(t, map[string]string{"a.txt": "A\n", "b.txt": "b\n"})
+
+	plan, err := adviseSplit(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(*prompt, `<hunk id="2" file="b.txt">`) {
+		t.Errorf("prompt = %q, want numbered hunks", *prompt)
+	}
+	if plan == nil || len(plan.Groups) != 2 || plan.Groups[0].Subject != "docs: add b" ||
+		!strings.Contains(plan.Groups[0].Patch, "+b\n") || strings.Contains(plan.Groups[0].Patch, "+A\n") {
+		t.Fatalf("adviseSplit() = %+v", plan)
+	}
+	comment := plan.comment()
+	if !strings.Contains(comment, "#   2. fix: change a\n#        a.txt @@ -1 +1 @@\n") {
+		t.Errorf("comment() = %q", comment)
+	}
+	for _, line := range strings.Split(strings.TrimSuffix(comment, "\n"), "\n") {
+		if !strings.HasPrefix(line, "#") {
+			t.Errorf("comment() line %q is not a comment", line)
+		}
+	}
+}
+
+func Test_adviseSplit_pathModes(t *testing.T) {
+	prompt := fakeAPI(t, `<group subject="refactor: rename old">3 4</group><group subject="fix: change a">1 2</group>`)
+	ctx := context.Background()
+	chdirTempRepo(t)
+	stageFiles(t, map[string]string{
+		".commitgptignore": "secret.txt\n",
+		".gitattributes":   "gen.txt commitgpt=summary\n",
+		"a.txt":            "a\n",
+		"old.txt":          "moved\n",
+	})
+	commitStaged(t, "feat: a")
+	// The patches must apply whatever the diff settings are.
+	for _, kv := range [][2]string{{"diff.noprefix", "true"}, {"diff.mnemonicPrefix", "true"}, {"diff.renames", "true"}} {
+		exec.Command("git", "config", kv[0], kv[1]).Run()
+	}
+	exec.Command("git", "mv", "old.txt", "new.txt").Run()
+	stageFiles(t, map[string]string{"a.txt": "A\n", "gen.txt": "generated\n", "secret.txt": "hunter2\n"})
+
+	plan, err := adviseSplit(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, hidden := range []string{"secret.txt", "hunter2", "generated"} {
+		if strings.Contains(*prompt, hidden) {
+			t.Errorf("prompt contains %q:\n%s", hidden, *prompt)
+		}
+	}
+	for _, want := range []string{
+		`<hunk id="2" file="gen.txt">` + "\n(summarised, diff not shown)\n",
+		`<hunk id="3" file="new.txt">`,
+		`<hunk id="4" file="old.txt">`,
+	} {
+		if !strings.Contains(*prompt, want) {
+			t.Errorf("prompt missing %q:\n%s", want, *prompt)
+		}
+	}
+	if plan == nil || len(plan.Groups) != 2 || !strings.Contains(plan.Groups[0].Patch, "+hunter2\n") {
+		t.Fatalf("adviseSplit() = %+v, want the ignored file in the first group", plan)
+	}
+	if err := saveSplitPlan(ctx, plan); err != nil {
+		t.Fatal(err)
+	}
+
+	want, _ := git(ctx, "write-tree")
+	for _, g := range plan.Groups {
+		if err := runSplit(ctx, nil); err != nil {
+			t.Fatalf("runSplit() err = %v", err)
+		}
+		commitStaged(t, g.Subject)
+	}
+	if got, _ := git(ctx, "rev-parse", "HEAD^{tree}"); string(got) != string(want) {
+		t.Errorf("tree after the split = %s, want %s", got, want)
+	}
+}
+
+// fakeAPI serves the Messages API, answering every request with text, for the
+// rest of the test. The prompt of the last request is stored in the result.
+func fakeAPI(t *testing.T, text string) *string {
+	var prompt string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Messages []struct {
+				Content string `json:"content"`
+			} `json:"messages"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		prompt = req.Messages[0].Content
+		json.NewEncoder(w).Encode(messageResponse{
+			StopReason: "end_turn",
+			Content:    []contentBlock{{Type: "text", Text: text}},
+		})
+	}))
+	t.Cleanup(ts.Close)
+	endpoint := Endpoint
+	t.Cleanup(func() { Endpoint = endpoint })
+	Endpoint = ts.URL
+	t.Setenv("ANTHROPIC_API_KEY", "test-api-key")
+	return &prompt
+}
+
+func fileExists(name string) bool {
+	_, err := os.Stat(name)
+	return err == nil
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	_ "embed"
+	"fmt"
+	"os"
+	"strings"
+	"text/template"
+)
+
+//go:embed message-template.txt
+var messageTemplateData string
+
+// MessageTemplate is a file with a text/template that replaces the default
+// layout of the commit message file.
+var MessageTemplate = ""
+
+// messageFields are the fields of the generated message given to the message
+// template. The sections are formatted for the commit message file, with the
+// warnings as comments, and Raw has them as the model wrote them.
+type messageFields struct {
+	SensitiveWarning  string
+	LargeFilesWarning string
+	Message           string
+	Thought           string
+	Raw               struct {
+		SensitiveWarning  string
+		LargeFilesWarning string
+		Message           string
+		Thought           string
+	}
+
+	ID         string
+	Model      string
+	Skipped    []string
+	StopReason string
+	Usage      struct {
+		InputTokens  int
+		OutputTokens int
+	}
+	InputCost  float64
+	OutputCost float64
+
+	// CommentChar starts comment lines, from core.commentChar.
+	CommentChar string
+}
+
+// templateFuncs are the functions that message templates may use besides the
+// text/template builtins.
+var templateFuncs = template.FuncMap{
+	"comment": commentLines,
+}
+
+// commentLines comments out each line of text with CommentChar.
+func commentLines(text string) string {
+	lines := strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+	for i, line := range lines {
+		if line == "" {
+			lines[i] = CommentChar
+		} else {
+			lines[i] = CommentChar + " " + line
+		}
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// messageTemplate parses MessageTemplate, or the default template.
+func messageTemplate() (*template.Template, error) {
+	text, name := messageTemplateData, "message-template.txt"
+	if MessageTemplate != "" {
+		data, err := os.ReadFile(MessageTemplate)
+		if err != nil {
+			return nil, err
+		}
+		text, name = string(data), MessageTemplate
+	}
+	tmpl, err := template.New(name).Funcs(templateFuncs).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("message template: %w", err)
+	}
+	return tmpl, nil
+}
+
+// executeMessageTemplate renders fields with the message template.
+func executeMessageTemplate(fields messageFields) (string, error) {
+	tmpl, err := messageTemplate()
+	if err != nil {
+		return "", err
+	}
+	var out strings.Builder
+	if err := tmpl.Execute(&out, fields); err != nil {
+		return "", fmt.Errorf("message template: %w", err)
+	}
+	return out.String(), nil
+}
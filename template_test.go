@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_commentLines(t *testing.T) {
+	tests := []struct {
+		text string
+		want string
+	}{
+		{"", "#\n"},
+		{"one", "# one\n"},
+		{"one\n\ntwo\n", "# one\n#\n# two\n"},
+	}
+	for _, tt := range tests {
+		if got := commentLines(tt.text); got != tt.want {
+			t.Errorf("commentLines(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
+
+func Test_renderResponse_template(t *testing.T) {
+	fakePandoc(t)
+	gen := generation{Model: modelSpec{Name: "claude-3-haiku-20240307"}}
+	gen.Response.Id = "msg_1"
+	gen.Response.StopReason = "end_turn"
+	gen.Response.Usage.InputTokens = 2000
+	gen.Response.Usage.OutputTokens = 100
+	gen.Response.Content = []contentBlock{{Type: "text", Text: "<sensitive-info-warning>\n- a.go, line 3: key\n</sensitive-info-warning>\n" +
+		"<thinkthrough>\nIt fixes a bug.\n</thinkthrough>\n<commit-message>\nfix: a bug\n</commit-message>"}}
+
+	tests := []struct {
+		name     string
+		template string
+		want     string
+		wantErr  bool
+	}{
+		{
+			name: "hide costs and thoughts, move warnings",
+			template: "{{.Message}}\n# ------------------------ >8 ------------------------\n" +
+				"{{.SensitiveWarning}}# {{.Model}} {{.ID}} {{.StopReason}} {{.Usage.InputTokens}}+{{.Usage.OutputTokens}}\n",
+			want: "fix: a bug\n\n# ------------------------ >8 ------------------------\n" +
+				"# **Sensitive Information Warning**\n# \n# - a.go, line 3: key\n" +
+				"# claude-3-haiku-20240307 msg_1 end_turn 2000+100",
+		},
+		{
+			name:     "raw sections and hints",
+			template: "{{.Raw.Message}}\n\n{{comment .Raw.Thought}}{{comment \"Run make test before pushing.\"}}",
+			want:     "fix: a bug\n\n# It fixes a bug.\n# Run make test before pushing.",
+		},
+		{
+			name:     "costs",
+			template: "{{printf \"%.4f %.4f\" .InputCost .OutputCost}}",
+			want:     "0.0005 0.0001",
+		},
+		{name: "parse error", template: "{{.Message", wantErr: true},
+		{name: "unknown field", template: "{{.Diff}}", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := filepath.Join(t.TempDir(), "message.tmpl")
+			if err := os.WriteFile(file, []byte(tt.template), 0644); err != nil {
+				t.Fatal(err)
+			}
+			defer func(v string) { MessageTemplate = v }(MessageTemplate)
+			MessageTemplate = file
+
+			got, err := renderResponse(context.Background(), gen)
+			if tt.wantErr {
+				var se *stageError
+				if !errors.As(err, &se) || se.Stage != stageFormat {
+					t.Errorf("renderResponse() error = %v, want a format error", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("renderResponse() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+var (
+	// Proxy is the URL of the proxy for API requests. When empty the
+	// HTTPS_PROXY and NO_PROXY environment variables are used.
+	Proxy string
+
+	// CABundle is a PEM file of additional root certificates to trust.
+	CABundle string
+
+	// ClientCert and ClientKey are PEM files of the certificate presented
+	// to servers that require mutual TLS.
+	ClientCert string
+	ClientKey  string
+
+	// Headers are extra "Name: value" headers sent with every API request.
+	// Values may refer to environment variables as $VAR or ${VAR}.
+	Headers []string
+)
+
+// newHTTPClient returns a client for API requests that is configured with the
+// transport settings above.
+func newHTTPClient() (*http.Client, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+
+	if Proxy != "" {
+		proxyURL, err := url.Parse(Proxy)
+		if err != nil {
+			return nil, fmt.Errorf("commitgpt.proxy: %w", err)
+		}
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+
+	if CABundle != "" || ClientCert != "" || ClientKey != "" {
+		transport.TLSClientConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+	}
+
+	if CABundle != "" {
+		pem, err := os.ReadFile(CABundle)
+		if err != nil {
+			return nil, fmt.Errorf("commitgpt.caBundle: %w", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("commitgpt.caBundle: no certificates found in %s", CABundle)
+		}
+		transport.TLSClientConfig.RootCAs = pool
+	}
+
+	if ClientCert != "" || ClientKey != "" {
+		if ClientCert == "" || ClientKey == "" {
+			return nil, fmt.Errorf("commitgpt.clientCert and commitgpt.clientKey must be set together")
+		}
+		cert, err := tls.LoadX509KeyPair(ClientCert, ClientKey)
+		if err != nil {
+			return nil, fmt.Errorf("commitgpt.clientCert: %w", err)
+		}
+		transport.TLSClientConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return &http.Client{Transport: transport}, nil
+}
+
+// setHeaders adds each "Name: value" header to h, expanding environment
+// variables in the value.
+func setHeaders(h http.Header, headers []string) error {
+	for _, header := range headers {
+		name, value, ok := strings.Cut(header, ":")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" {
+			return fmt.Errorf("invalid header %q: want \"Name: value\"", header)
+		}
+		h.Set(name, os.ExpandEnv(strings.TrimSpace(value)))
+	}
+	return nil
+}
+
+// setAPIKey authenticates the request with apiKey in the named header. The
+// Authorization header is sent as a bearer token.
+func setAPIKey(h http.Header, header, apiKey string) {
+	if header == "" {
+		header = "x-api-key"
+	}
+	if strings.EqualFold(header, "Authorization") {
+		apiKey = "Bearer " + apiKey
+	}
+	h.Set(header, apiKey)
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const transportTestResponse = `{"id":"1","content":[{"type":"text","text":"<commit-message>\nfix: x\n</commit-message>"}],"stop_reason":"end_turn"}`
+
+func transportTestHandler(check func(r *http.Request)) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if check != nil {
+			check(r)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, transportTestResponse)
+	})
+}
+
+// resetTransport restores the transport settings when the test is done.
+func resetTransport(t *testing.T) {
+	endpoint, providers := Endpoint, Providers
+	proxy, caBundle, clientCert, clientKey, headers := Proxy, CABundle, ClientCert, ClientKey, Headers
+	t.Cleanup(func() {
+		Endpoint, Providers = endpoint, providers
+		Proxy, CABundle, ClientCert, ClientKey, Headers = proxy, caBundle, clientCert, clientKey, headers
+	})
+	t.Setenv("ANTHROPIC_API_KEY", "test-api-key")
+}
+
+func writePEM(t *testing.T, name, blockType string, der []byte) string {
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// newClientCert returns a self-signed client certificate, written to PEM
+// files, and a pool that trusts it.
+func newClientCert(t *testing.T) (certFile, keyFile string, pool *x509.CertPool) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "commitgpt test client"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool = x509.NewCertPool()
+	pool.AddCert(cert)
+	return writePEM(t, "client.crt", "CERTIFICATE", der), writePEM(t, "client.key", "EC PRIVATE KEY", keyDER), pool
+}
+
+func Test_newHTTPClient_caBundle(t *testing.T) {
+	resetTransport(t)
+	ts := httptest.NewTLSServer(transportTestHandler(nil))
+	defer ts.Close()
+	Endpoint = ts.URL
+
+	CABundle = ""
+	if _, err := sendMessage(context.Background(), modelSpec{Name: "claude"}, "main", "diff"); err == nil {
+		t.Error("sendMessage() expected certificate error without CA bundle")
+	}
+
+	CABundle = writePEM(t, "ca.pem", "CERTIFICATE", ts.Certificate().Raw)
+	if _, err := sendMessage(context.Background(), modelSpec{Name: "claude"}, "main", "diff"); err != nil {
+		t.Errorf("sendMessage() err = %v", err)
+	}
+
+	CABundle = filepath.Join(t.TempDir(), "missing.pem")
+	if _, err := newHTTPClient(); err == nil {
+		t.Error("newHTTPClient() expected error for missing CA bundle")
+	}
+}
+
+func Test_newHTTPClient_clientCert(t *testing.T) {
+	resetTransport(t)
+	certFile, keyFile, pool := newClientCert(t)
+	ts := httptest.NewUnstartedServer(transportTestHandler(func(r *http.Request) {
+		if len(r.TLS.PeerCertificates) == 0 {
+			t.Error("no client certificate")
+		}
+	}))
+	ts.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: pool}
+	ts.StartTLS()
+	defer ts.Close()
+	Endpoint = ts.URL
+	CABundle = writePEM(t, "ca.pem", "CERTIFICATE", ts.Certificate().Raw)
+
+	if _, err := sendMessage(context.Background(), modelSpec{Name: "claude"}, "main", "diff"); err == nil {
+		t.Error("sendMessage() expected handshake error without client certificate")
+	}
+
+	ClientCert, ClientKey = certFile, keyFile
+	if _, err := sendMessage(context.Background(), modelSpec{Name: "claude"}, "main", "diff"); err != nil {
+		t.Errorf("sendMessage() err = %v", err)
+	}
+
+	ClientKey = ""
+	if _, err := newHTTPClient(); err == nil {
+		t.Error("newHTTPClient() expected error for certificate without key")
+	}
+}
+
+func Test_newHTTPClient_proxy(t *testing.T) {
+	resetTransport(t)
+	var proxied bool
+	proxy := httptest.NewServer(transportTestHandler(func(r *http.Request) {
+		proxied = true
+		if r.URL.Host != "api.example.invalid" {
+			t.Errorf("unexpected proxied host: %s", r.URL.Host)
+		}
+	}))
+	defer proxy.Close()
+	Endpoint = "http://api.example.invalid/v1/messages"
+	Proxy = proxy.URL
+
+	if _, err := sendMessage(context.Background(), modelSpec{Name: "claude"}, "main", "diff"); err != nil {
+		t.Errorf("sendMessage() err = %v", err)
+	}
+	if !proxied {
+		t.Error("request did not go through the proxy")
+	}
+}
+
+func Test_sendMessage_headers(t *testing.T) {
+	resetTransport(t)
+	t.Setenv("GATEWAY_TOKEN", "gateway-token")
+	ts := httptest.NewServer(transportTestHandler(func(r *http.Request) {
+		if got := r.Header.Get("Authorization"); got != "Bearer gateway-token" {
+			t.Errorf("unexpected Authorization: %s", got)
+		}
+		if got := r.Header.Get("x-api-key"); got != "" {
+			t.Errorf("unexpected x-api-key: %s", got)
+		}
+		if got := r.Header.Get("X-Team"); got != "platform" {
+			t.Errorf("unexpected X-Team: %s", got)
+		}
+		if got := r.Header.Get("X-Env"); got != "gateway-token" {
+			t.Errorf("unexpected X-Env: %s", got)
+		}
+	}))
+	defer ts.Close()
+	Headers = []string{"X-Team: web", "X-Env: ${GATEWAY_TOKEN}"}
+	Providers = map[string]provider{
+		"gateway": {
+			Endpoint:     ts.URL,
+			APIKeyEnv:    "GATEWAY_TOKEN",
+			APIKeyHeader: "Authorization",
+			Headers:      []string{"X-Team: platform"},
+		},
+	}
+
+	if _, err := sendMessage(context.Background(), modelSpec{Provider: "gateway", Name: "claude"}, "main", "diff"); err != nil {
+		t.Errorf("sendMessage() err = %v", err)
+	}
+	if len(Headers) != 2 {
+		t.Errorf("provider headers leaked into global headers: %q", Headers)
+	}
+
+	Headers = []string{"no colon"}
+	if _, err := sendMessage(context.Background(), modelSpec{Provider: "gateway", Name: "claude"}, "main", "diff"); err == nil {
+		t.Error("sendMessage() expected error for invalid header")
+	}
+}